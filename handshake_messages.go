@@ -0,0 +1,205 @@
+// Reassembly of plaintext TLS handshake messages from captured frames and
+// extraction of negotiated parameters. Shared by the client and the reporter.
+package main
+
+import (
+	"bytes"
+)
+
+// TLS record and handshake message types
+const (
+	recordTypeChangeCipherSpec uint8 = 20
+	recordTypeAlert            uint8 = 21
+	recordTypeHandshake        uint8 = 22
+	typeClientHello            uint8 = 1
+	typeServerHello            uint8 = 2
+	typeHelloRetryRequest      uint8 = 6 // before TLS 1.3 draft 22
+	typeServerKeyExchange      uint8 = 12
+	curveTypeNamedCurve        uint8 = 3
+)
+
+// TLS extension types
+const (
+	extensionServerName          uint16 = 0
+	extensionSupportedGroups     uint16 = 10
+	extensionECPointFormats      uint16 = 11
+	extensionSignatureAlgorithms uint16 = 13
+	extensionALPN                uint16 = 16
+	extensionPadding             uint16 = 21
+	extensionKeyShareDraft22     uint16 = 40
+	extensionSupportedVersions   uint16 = 43
+	extensionPSKKeyExchangeModes uint16 = 45
+	extensionKeyShare            uint16 = 51
+)
+
+// helloRetryRequestRandom is the ServerHello random that marks a
+// HelloRetryRequest (since TLS 1.3 draft 22).
+var helloRetryRequestRandom = []byte{
+	0xcf, 0x21, 0xad, 0x74, 0xe5, 0x9a, 0x61, 0x11, 0xbe, 0x1d, 0x8c, 0x02, 0x1e, 0x65, 0xb8, 0x91,
+	0xc2, 0xa2, 0x11, 0x16, 0x7a, 0xbb, 0x8c, 0x5e, 0x07, 0x9e, 0x09, 0xe2, 0xc8, 0xa8, 0x33, 0x9c,
+}
+
+// Downgrade sentinels in the last eight bytes of the ServerHello random, set by
+// TLS 1.3 servers that negotiate TLS 1.2 or TLS 1.1 and older, respectively.
+var (
+	downgradeSentinelTLS12 = []byte("DOWNGRD\x01")
+	downgradeSentinelTLS11 = []byte("DOWNGRD\x00")
+)
+
+// extractHandshakeMessages reassembles the plaintext handshake messages from
+// frames that were read (isRead is true) or written. Each message includes
+// its four-byte header. Parsing stops at the first ChangeCipherSpec or other
+// record after which messages are encrypted, or at a truncated record.
+//
+// Note: a ClientHello that is sent after a HelloRetryRequest follows a
+// ChangeCipherSpec in middlebox compatibility mode and is not returned.
+func extractHandshakeMessages(frames []Frame, isRead bool) [][]byte {
+	var stream []byte
+	for _, frame := range frames {
+		if frame.IsRead == isRead {
+			stream = append(stream, frame.Data...)
+		}
+	}
+
+	// collect fragments of handshake records: content type (1), version
+	// (2), length (2) and the fragment.
+	var handshake []byte
+records:
+	for len(stream) >= 5 {
+		contentType := stream[0]
+		length := int(stream[3])<<8 | int(stream[4])
+		if len(stream) < 5+length {
+			break
+		}
+		fragment := stream[5 : 5+length]
+		stream = stream[5+length:]
+		switch contentType {
+		case recordTypeHandshake:
+			handshake = append(handshake, fragment...)
+		case recordTypeAlert:
+			// plaintext alerts do not affect the handshake stream.
+		default:
+			break records
+		}
+	}
+
+	// split handshake stream in messages.
+	var messages [][]byte
+	for len(handshake) >= 4 {
+		length := int(handshake[1])<<16 | int(handshake[2])<<8 | int(handshake[3])
+		if len(handshake) < 4+length {
+			break
+		}
+		messages = append(messages, handshake[:4+length])
+		handshake = handshake[4+length:]
+	}
+	return messages
+}
+
+// findHandshakeMessage returns the first message of the given type or nil if
+// there is none.
+func findHandshakeMessage(messages [][]byte, msgType uint8) []byte {
+	for _, message := range messages {
+		if message[0] == msgType {
+			return message
+		}
+	}
+	return nil
+}
+
+// serverHelloRandom returns the random field of a ServerHello message (with
+// header) or nil if the message is too short.
+func serverHelloRandom(message []byte) []byte {
+	// header (4), legacy_version (2), random (32)
+	if len(message) < 4+2+32 {
+		return nil
+	}
+	return message[6 : 6+32]
+}
+
+// hasDowngradeSentinel returns true if the random of the ServerHello message
+// (with header) contains a downgrade sentinel.
+func hasDowngradeSentinel(message []byte) bool {
+	random := serverHelloRandom(message)
+	if random == nil {
+		return false
+	}
+	sentinel := random[len(random)-8:]
+	return bytes.Equal(sentinel, downgradeSentinelTLS12) ||
+		bytes.Equal(sentinel, downgradeSentinelTLS11)
+}
+
+// serverHelloExtension returns the contents of an extension in a ServerHello
+// message (with header) or nil if the extension is not present.
+func serverHelloExtension(message []byte, extType uint16) []byte {
+	// header (4), legacy_version (2), random (32)
+	pos := 4 + 2 + 32
+	if len(message) < pos+1 {
+		return nil
+	}
+	// legacy_session_id_echo, cipher_suite (2), compression_method (1)
+	pos += 1 + int(message[pos]) + 2 + 1
+	if len(message) < pos+2 {
+		return nil
+	}
+	end := pos + 2 + (int(message[pos])<<8 | int(message[pos+1]))
+	if len(message) < end {
+		return nil
+	}
+	for pos += 2; pos+4 <= end; {
+		thisType := uint16(message[pos])<<8 | uint16(message[pos+1])
+		length := int(message[pos+2])<<8 | int(message[pos+3])
+		pos += 4
+		if pos+length > end {
+			return nil
+		}
+		if thisType == extType {
+			return message[pos : pos+length]
+		}
+		pos += length
+	}
+	return nil
+}
+
+// handshakeParams contains negotiated parameters that are not exposed by the
+// TLS connection state.
+type handshakeParams struct {
+	KeyExchangeGroup  uint16
+	HelloRetryRequest bool
+	// whether the ServerHello signals a downgrade from TLS 1.3.
+	DowngradeSentinel bool
+}
+
+// parseHandshakeParams determines the negotiated parameters from the messages
+// that were sent by the server (isRead is true for the client side).
+func parseHandshakeParams(frames []Frame, isRead bool) handshakeParams {
+	var params handshakeParams
+	for _, message := range extractHandshakeMessages(frames, isRead) {
+		switch message[0] {
+		case typeHelloRetryRequest:
+			params.HelloRetryRequest = true
+		case typeServerHello:
+			if bytes.Equal(serverHelloRandom(message), helloRetryRequestRandom) {
+				params.HelloRetryRequest = true
+			} else if hasDowngradeSentinel(message) {
+				params.DowngradeSentinel = true
+			}
+			// the key share of a ServerHello and the selected
+			// group of a HelloRetryRequest both start with the
+			// group (which must be the same for both).
+			keyShare := serverHelloExtension(message, extensionKeyShare)
+			if keyShare == nil {
+				keyShare = serverHelloExtension(message, extensionKeyShareDraft22)
+			}
+			if len(keyShare) >= 2 {
+				params.KeyExchangeGroup = uint16(keyShare[0])<<8 | uint16(keyShare[1])
+			}
+		case typeServerKeyExchange:
+			// ECDHE parameters: curve_type (1) and named_curve (2).
+			if len(message) >= 4+3 && message[4] == curveTypeNamedCurve {
+				params.KeyExchangeGroup = uint16(message[5])<<8 | uint16(message[6])
+			}
+		}
+	}
+	return params
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
@@ -23,6 +24,12 @@ var (
 	connectionsRWLock sync.RWMutex
 )
 
+// Number of attempts and delay for retrieving a pending anonymous test result.
+const (
+	testResultAttempts   = 5
+	testResultRetryDelay = time.Second
+)
+
 // Experiments configuration
 type Experiment struct {
 	Domain  string
@@ -76,7 +83,7 @@ func specToDomain(testId string, spec SubtestSpec) string {
 	return fmt.Sprintf("%s-%d.%s", testId, spec.Number, domain)
 }
 
-func gatherTests(verbose bool) (string, []SubtestSpec, error) {
+func gatherTests(verbose bool) (*createTestResponse, error) {
 	clientVersion := js.Global.Get("jssockClientVersion").String()
 	testRequest := createTestRequest{
 		ClientVersion: clientVersion,
@@ -86,7 +93,7 @@ func gatherTests(verbose bool) (string, []SubtestSpec, error) {
 	return CreateTest(testRequest, !verbose)
 }
 
-func runTests(testId string, specs []SubtestSpec, verbose bool) {
+func runTests(testId string, specs []SubtestSpec, pins []string, verbose bool) {
 	experiments := make([]Experiment, len(specs))
 	var wg sync.WaitGroup
 	for i, spec := range specs {
@@ -101,18 +108,20 @@ func runTests(testId string, specs []SubtestSpec, verbose bool) {
 				Frames:    []Frame{},
 				HasFailed: true,
 			}
-			response, err := tryTLS(domain, spec.MaxTLSVersion, &result)
+			response, err := tryTLS(domain, spec.MaxTLSVersion, pins, &result)
 			result.EndTime = time.Now().UTC()
-			if verbose {
-				go func() {
-					err := SaveTestResult(testId, spec.Number, result)
-					if err != nil {
-						js.Global.Get("console").Call("log",
-							fmt.Sprintf("SaveTestResult(%s, %d) failed: %s",
-								testId, spec.Number, err))
-					}
-				}()
-			}
+			// results of anonymous tests are only kept in memory
+			// by the server.
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := SaveTestResult(testId, spec.Number, result)
+				if err != nil {
+					js.Global.Get("console").Call("log",
+						fmt.Sprintf("SaveTestResult(%s, %d) failed: %s",
+							testId, spec.Number, err))
+				}
+			}()
 
 			// TODO rewrite this, remove Experiment struct.
 			// Currently only here to avoid changing frontend
@@ -130,11 +139,22 @@ func runTests(testId string, specs []SubtestSpec, verbose bool) {
 			// if a version is negotiated, but does not match the
 			// expected version, it is likely being intercepted.
 			if result.ActualTLSVersion != 0 {
-				maxTLSVersion := spec.MaxTLSVersion
-				if maxTLSVersion == tls.VersionTLS13 {
-					maxTLSVersion = tls.VersionTLS13Draft22
+				expectedVersion := spec.ExpectedVersion
+				if expectedVersion == 0 {
+					expectedVersion = spec.MaxTLSVersion
+					if expectedVersion == tls.VersionTLS13 {
+						expectedVersion = tls.VersionTLS13Draft22
+					}
 				}
-				exp.IsMitm = maxTLSVersion != result.ActualTLSVersion
+				exp.IsMitm = expectedVersion != result.ActualTLSVersion
+			}
+			// a certificate from another issuer is a clear sign.
+			if result.PinStatus == pinStatusMismatch {
+				exp.IsMitm = true
+			}
+			// our server saw a ClientHello without TLS 1.3 support.
+			if spec.MaxTLSVersion == tls.VersionTLS13 && result.DowngradeSentinel {
+				exp.IsMitm = true
 			}
 			// display in UI
 			updateExperiment(i, exp)
@@ -146,16 +166,58 @@ func runTests(testId string, specs []SubtestSpec, verbose bool) {
 		// Calling console.log for now because it hides private fields.
 		js.Global.Get("console").Call("log", exp)
 	}
+
+	// anonymous results are not stored, retrieve the server-side view now.
+	if !verbose {
+		testResult, err := getCompleteTestResult(testId)
+		if err != nil {
+			js.Global.Get("console").Call("log",
+				fmt.Sprintf("GetTestResult(%s) failed: %s", testId, err))
+			return
+		}
+		js.Global.Get("console").Call("log", testResult)
+	}
+}
+
+// getCompleteTestResult retrieves the result of an anonymous test. Server
+// captures complete only after the server has closed the connection, so the
+// request is repeated for a while if the result is still pending.
+func getCompleteTestResult(testId string) (map[string]interface{}, error) {
+	for attempt := 1; ; attempt++ {
+		testResult, err := GetTestResult(testId)
+		if err != nil {
+			return nil, err
+		}
+		isPending, _ := testResult["is_pending"].(bool)
+		if !isPending || attempt == testResultAttempts {
+			return testResult, nil
+		}
+		time.Sleep(testResultRetryDelay)
+	}
+}
+
+func updateDeletionToken(testId, deletionToken string) {
+	if fn := js.Global.Get("updateDeletionToken"); fn != js.Undefined {
+		go func() {
+			fn.Invoke(testId, deletionToken)
+		}()
+	}
 }
 
 // startTests retrieves test cases, executes them and optionally submits test
 // results back to the server.
 func StartTests(verbose bool) {
-	testId, specs, err := gatherTests(verbose)
+	testResponse, err := gatherTests(verbose)
 	if err != nil {
 		// TODO this could be a network error, show message to user
 		panic(err)
 	}
+	testId, specs := testResponse.TestID, testResponse.Subtests
+
+	// allow the user to withdraw the results later.
+	if testResponse.DeletionToken != "" {
+		updateDeletionToken(testId, testResponse.DeletionToken)
+	}
 
 	// display tests in UI
 	for _, spec := range specs {
@@ -167,7 +229,7 @@ func StartTests(verbose bool) {
 		addExperiment(exp)
 	}
 
-	runTests(testId, specs, verbose)
+	runTests(testId, specs, testResponse.SPKIPins, verbose)
 }
 
 type JsApi struct{}
@@ -176,6 +238,18 @@ func (*JsApi) StartTests(verbose bool) {
 	go StartTests(verbose)
 }
 
+// DeleteTest removes previously submitted test results. The callback is
+// invoked with an error message (or null on success).
+func (*JsApi) DeleteTest(testId, deletionToken string, callback *js.Object) {
+	go func() {
+		var errMsg interface{}
+		if err := DeleteTest(testId, deletionToken); err != nil {
+			errMsg = err.Error()
+		}
+		callback.Invoke(errMsg)
+	}()
+}
+
 func registerJSApi() {
 	jsApi := &JsApi{}
 	js.Global.Set("jssock", js.MakeWrapper(jsApi))
@@ -188,7 +262,26 @@ func main() {
 	updateStatus("booted")
 }
 
-func tryTLS(domain string, version uint16, result *clientResult) (string, error) {
+// checkSPKIPins returns whether the leaf certificate matches one of the pins.
+func checkSPKIPins(rawCerts [][]byte, pins []string) string {
+	if len(rawCerts) == 0 {
+		return ""
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return pinStatusMismatch
+	}
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(hash[:])
+	for _, expected := range pins {
+		if pin == expected {
+			return pinStatusMatch
+		}
+	}
+	return pinStatusMismatch
+}
+
+func tryTLS(domain string, version uint16, pins []string, result *clientResult) (string, error) {
 	conn, err := DialTCP("tcp", net.JoinHostPort(domain, tlsPort))
 	if err != nil {
 		return "", err
@@ -199,23 +292,42 @@ func tryTLS(domain string, version uint16, result *clientResult) (string, error)
 		ServerName:   domain,
 		KeyLogWriter: keylog,
 		MaxVersion:   version,
-	}
-	var rootCAs *x509.CertPool
-	if rootCAs != nil {
-		tls_config.RootCAs = rootCAs
-	} else {
-		tls_config.InsecureSkipVerify = true
+		// Do not abort the handshake for an unexpected certificate,
+		// that would hide the interception. Check the pins instead.
+		InsecureSkipVerify: true,
+	}
+	if len(pins) > 0 {
+		tls_config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			result.PinStatus = checkSPKIPins(rawCerts, pins)
+			return nil
+		}
 	}
 
 	tappedConn := NewCaptureConn(conn, &result.Frames)
 	tls_conn := tls.Client(tappedConn, tls_config)
 
-	if err := tls_conn.Handshake(); err != nil {
+	err = tls_conn.Handshake()
+	// these parameters are also available for failed handshakes.
+	params := parseHandshakeParams(result.Frames, true)
+	result.KeyExchangeGroup = params.KeyExchangeGroup
+	result.HelloRetryRequest = params.HelloRetryRequest
+	result.DowngradeSentinel = params.DowngradeSentinel
+	if err != nil {
 		return "", err
 	}
-	// Handshake successful, store version and keys
-	result.ActualTLSVersion = tls_conn.ConnectionState().Version
+	// Handshake successful, store version, keys and certificates (these
+	// are not validated, the reporter checks for substitution).
+	state := tls_conn.ConnectionState()
+	result.ActualTLSVersion = state.Version
+	result.CipherSuite = state.CipherSuite
+	result.ALPNProtocol = state.NegotiatedProtocol
+	result.IsResumed = state.DidResume
 	result.KeyLog = keylog.lines
+	for _, cert := range state.PeerCertificates {
+		spkiHash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		result.PeerCertificates = append(result.PeerCertificates, cert.Raw)
+		result.SPKIHashes = append(result.SPKIHashes, base64.StdEncoding.EncodeToString(spkiHash[:]))
+	}
 
 	request := fmt.Sprintf("GET / HTTP/1.1\r\nHost: %s\r\n\r\n", domain)
 	n, err := tls_conn.Write([]byte(request))
@@ -18,6 +18,11 @@ type createTestRequest struct {
 type createTestResponse struct {
 	TestID   string        `json:"test_id"`
 	Subtests []SubtestSpec `json:"subtests"`
+	// Permits removal of the test results (not set for anonymous tests).
+	DeletionToken string `json:"deletion_token"`
+	// base64-encoded SHA-256 hashes of the SubjectPublicKeyInfo of the
+	// certificate that is used by the test service.
+	SPKIPins []string `json:"spki_pins"`
 }
 
 // Similar to ClientCapture on the server, but without CreatedAt field.
@@ -28,13 +33,37 @@ type clientResult struct {
 	Frames           []Frame   `json:"frames"`
 	KeyLog           string    `json:"key_log"`
 	HasFailed        bool      `json:"has_failed"`
+	// Negotiated parameters (zero if the handshake failed).
+	CipherSuite       uint16 `json:"cipher_suite"`
+	KeyExchangeGroup  uint16 `json:"key_exchange_group"`
+	ALPNProtocol      string `json:"alpn_protocol"`
+	IsResumed         bool   `json:"is_resumed"`
+	HelloRetryRequest bool   `json:"hello_retry_request"`
+	// Certificate chain (DER) as received and the base64-encoded SHA-256
+	// hashes of their SubjectPublicKeyInfo.
+	PeerCertificates [][]byte `json:"peer_certificates"`
+	SPKIHashes       []string `json:"spki_hashes"`
+	// Result of the SPKI pin check (see pinStatus*).
+	PinStatus string `json:"pin_status"`
+	// Whether the ServerHello contained a downgrade sentinel.
+	DowngradeSentinel bool `json:"downgrade_sentinel"`
 }
 
+// Result of the SPKI pin check. It is empty if no certificate was received or if
+// no pins were available.
+const (
+	pinStatusMatch    = "match"
+	pinStatusMismatch = "mismatch"
+)
+
 // Specification of a subtest
 type SubtestSpec struct {
 	Number        int    `json:"number"`
 	MaxTLSVersion uint16 `json:"max_tls_version"`
 	IsIPv6        bool   `json:"is_ipv6"`
+	// Exact version that should be negotiated (if not set, MaxTLSVersion
+	// is expected, or draft 22 for TLS 1.3).
+	ExpectedVersion uint16 `json:"expected_version"`
 }
 
 type Frame struct {
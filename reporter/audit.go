@@ -0,0 +1,148 @@
+// Audit log of requests to privileged API endpoints, such that it is known who
+// accessed the results of a user.
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditAccess records the request after it was handled. It must precede
+// makeAuthRequired, such that rejected requests are recorded as well (without
+// API key name).
+func (r *reporter) auditAccess(c *gin.Context) {
+	c.Next()
+
+	entry := &AuditLog{
+		ApiKeyName: c.GetString(apiKeyNameKey),
+		Method:     c.Request.Method,
+		Path:       c.Request.URL.Path,
+		TestID:     c.Param("testid"),
+		ClientIP:   net.ParseIP(parseHost(c.Request.RemoteAddr)),
+		Status:     c.Writer.Status(),
+	}
+	if err := entry.Create(r.db); err != nil {
+		log.Printf("Failed to write audit log for %q: %s %s: %s", entry.ApiKeyName,
+			entry.Method, entry.Path, err)
+	}
+}
+
+// auditLogQuery is a page of the audit log, newest entries first.
+type auditLogQuery struct {
+	conditions []string
+	args       []interface{}
+	limit      int
+}
+
+// SQL returns the query suffix and arguments. One more row than the limit is
+// requested to learn whether there is a next page.
+func (q *auditLogQuery) SQL() (string, []interface{}) {
+	var query string
+	if len(q.conditions) > 0 {
+		query = "WHERE " + strings.Join(q.conditions, " AND ") + "\n"
+	}
+	args := append(q.args[:len(q.args):len(q.args)], q.limit+1)
+	query += fmt.Sprintf("ORDER BY id DESC\nLIMIT $%d", len(args))
+	return query, args
+}
+
+func (q *auditLogQuery) where(column string, value interface{}) {
+	q.args = append(q.args, value)
+	q.conditions = append(q.conditions, fmt.Sprintf("%s = $%d", column, len(q.args)))
+}
+
+// encodeAuditCursor returns an opaque cursor that continues after the entry.
+func encodeAuditCursor(entry *AuditLog) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(entry.ID)))
+}
+
+func decodeAuditCursor(cursor string) (int, error) {
+	value, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errors.New("invalid cursor")
+	}
+	id, err := strconv.Atoi(string(value))
+	if err != nil || id <= 0 {
+		return 0, errors.New("invalid cursor")
+	}
+	return id, nil
+}
+
+// parseAuditLogQuery builds a query from the URL query parameters.
+func parseAuditLogQuery(params url.Values) (*auditLogQuery, error) {
+	q := &auditLogQuery{limit: defaultListLimit}
+
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxListLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+		q.limit = limit
+	}
+
+	for _, column := range []string{"api_key_name", "test_id"} {
+		if value := params.Get(column); value != "" {
+			q.where(column, value)
+		}
+	}
+
+	if cursor := params.Get("cursor"); cursor != "" {
+		id, err := decodeAuditCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		q.args = append(q.args, id)
+		q.conditions = append(q.conditions, fmt.Sprintf("id < $%d", len(q.args)))
+	}
+	return q, nil
+}
+
+func (r *reporter) listAuditLog(c *gin.Context) {
+	listQuery, err := parseAuditLogQuery(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	extraQuery, args := listQuery.SQL()
+	rows, err := QueryAuditLogs(r.db, append([]interface{}{extraQuery}, args...)...)
+	if err != nil {
+		r.dbError(c, err)
+		return
+	}
+	defer rows.Close()
+
+	entries := []*AuditLog{}
+	for rows.Next() {
+		entry, err := ScanAuditLog(rows)
+		if err != nil {
+			r.dbError(c, err)
+			return
+		}
+		entries = append(entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		r.dbError(c, err)
+		return
+	}
+
+	var next interface{}
+	if len(entries) > listQuery.limit {
+		entries = entries[:listQuery.limit]
+		next = encodeAuditCursor(entries[len(entries)-1])
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"result": entries,
+		"next":   next,
+	})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseAuditLogQuery(t *testing.T) {
+	params := url.Values{
+		"limit":        {"5"},
+		"api_key_name": {"alice"},
+		"test_id":      {"6b5742d9-722b-4d12-848a-c42da771b806"},
+		"cursor":       {encodeAuditCursor(&AuditLog{ID: 42})},
+	}
+	q, err := parseAuditLogQuery(params)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	query, args := q.SQL()
+	expectedQuery := "WHERE api_key_name = $1 AND test_id = $2 AND id < $3\n" +
+		"ORDER BY id DESC\nLIMIT $4"
+	if query != expectedQuery {
+		t.Errorf("unexpected query: %s", query)
+	}
+	expectedArgs := []interface{}{"alice", "6b5742d9-722b-4d12-848a-c42da771b806", 42, 6}
+	if !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("unexpected args: %v", args)
+	}
+
+	for _, params := range []url.Values{
+		{"limit": {"0"}},
+		{"cursor": {"bm90LWEtY3Vyc29y"}},
+	} {
+		if _, err := parseAuditLogQuery(params); err == nil {
+			t.Errorf("expected error for %v", params)
+		}
+	}
+}
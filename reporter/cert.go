@@ -2,7 +2,11 @@
 package main
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
 	"log"
 	"os"
 	"sync"
@@ -80,3 +84,17 @@ func (cl *CertificateLoader) checkStaleCache() bool {
 	cl.mtime = finfo.ModTime()
 	return false
 }
+
+// leafSPKIPin returns the base64-encoded SHA-256 hash of the
+// SubjectPublicKeyInfo of the leaf certificate.
+func leafSPKIPin(cert *tls.Certificate) (string, error) {
+	if len(cert.Certificate) == 0 {
+		return "", errors.New("certificate chain is empty")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:]), nil
+}
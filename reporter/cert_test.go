@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestLeafSPKIPin(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spki, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256(spki)
+	expected := base64.StdEncoding.EncodeToString(hash[:])
+
+	pin, err := leafSPKIPin(&tls.Certificate{Certificate: [][]byte{der, {1, 2, 3}}})
+	if err != nil || pin != expected {
+		t.Errorf("expected %q, got %q (%v)", expected, pin, err)
+	}
+	if _, err := leafSPKIPin(&tls.Certificate{}); err == nil {
+		t.Error("expected error for empty chain")
+	}
+	if _, err := leafSPKIPin(&tls.Certificate{Certificate: [][]byte{{1, 2, 3}}}); err == nil {
+		t.Error("expected error for invalid certificate")
+	}
+}
@@ -3,15 +3,57 @@ package main
 import (
 	"crypto/tls"
 	"encoding/json"
-	"os"
+	"fmt"
+	"io/ioutil"
+	"log"
 )
 
+// RateLimit configures a token bucket.
+type RateLimit struct {
+	// Number of requests per minute that are allowed on average.
+	PerMinute int
+	// Maximum number of requests that can be made in a burst.
+	Burst int
+}
+
+// ApiKey grants access to privileged reporter API endpoints.
+type ApiKey struct {
+	// Name of the key holder, used for logging.
+	Name string
+	// SHA256 hash of the API key. The API key MUST be cryptographically
+	// random.
+	Hash string
+	// Either "read" (read-only access to results) or "write" (read access
+	// and modifications such as removal of tests).
+	Role string
+}
+
+// Roles of API keys. The write role implies the read role.
+const (
+	roleRead  = "read"
+	roleWrite = "write"
+)
+
+// Name of the API key that is migrated from the ReporterApiKeyHash setting.
+const legacyApiKeyName = "legacy"
+
 type Config struct {
 	// Maximum allowed time after test creation in which updates (like
 	// client results) are accepted.
 	MutableTestPeriodSecs int
 
-	// Test cases that the client should execute.
+	// Interval in seconds between checks for pending tests which are no
+	// longer mutable. Such tests are concluded with the available results.
+	PendingTestExpiryIntervalSecs int
+
+	// Period in seconds for which the results of anonymous tests are kept
+	// in memory (they are never stored in the database).
+	EphemeralTestTTLSecs int
+
+	// Test cases that the client should execute. The ExpectedVersion of a
+	// subtest must be negotiable by the TLS library (see
+	// negotiatedTLSVersion). A TLS 1.3 subtest is only negotiated with TLS
+	// 1.3 if the client offers the expected version.
 	Subtests []SubtestSpec
 
 	// Timeout for reading the initial Client Hello message.
@@ -55,20 +97,82 @@ type Config struct {
 	// Private key file for the dummy test service.
 	DummyPrivateKey string
 
-	// SHA256 hash of the API key that grants access to privileged reporter
-	// API endpoints. The API key MUST be cryptographically random. An empty
-	// value prevents access to the privileged endpoint.
-	ReporterApiKeyHash string
+	// JSON file with fingerprints of known middleboxes, an array of objects
+	// with "vendor", "ja3" (ClientHello received by the server) and "ja3s"
+	// (ServerHello received by the client). Empty disables attribution.
+	MiddleboxFingerprintsFile string
+
+	// Rate limits per client IP address (or IPv6 /64 prefix) for creating
+	// tests and for submitting results (comments and client results). A
+	// zero PerMinute value disables the limit.
+	CreateTestRateLimit RateLimit
+	SubmitRateLimit     RateLimit
+
+	// Period in seconds after which the frames and key logs of captures
+	// are removed. Zero keeps them forever. Must be longer than
+	// MutableTestPeriodSecs.
+	CaptureDataRetentionSecs int
+	// Period in seconds after which tests are removed, including their
+	// subtests and captures. Zero keeps them forever.
+	TestRetentionSecs int
+	// Interval in seconds between checks for expired data.
+	RetentionIntervalSecs int
+	// Maximum number of rows that are changed in a single query.
+	RetentionBatchSize int
+
+	// API keys that grant access to privileged reporter API endpoints. An
+	// empty list prevents access to the privileged endpoints.
+	ReporterApiKeys []ApiKey
+}
+
+// TLS 1.3 draft that is negotiated by the client and server.
+const versionTLS13Draft22 uint16 = 0x7f16
+
+// negotiatedTLSVersion returns the version that the TLS library of the client
+// and server negotiates with the given maximum version. For TLS 1.3 the library
+// implements a fixed set of drafts, prefers draft 22 and does not allow
+// selecting another draft (or the final version) per connection.
+func negotiatedTLSVersion(maxTLSVersion uint16) uint16 {
+	if maxTLSVersion == tls.VersionTLS13 {
+		return versionTLS13Draft22
+	}
+	return maxTLSVersion
+}
+
+// expectedTLSVersion returns the version that should be negotiated if the
+// connection is not intercepted.
+func (spec *SubtestSpec) expectedTLSVersion() uint16 {
+	if spec.ExpectedVersion != 0 {
+		return spec.ExpectedVersion
+	}
+	return negotiatedTLSVersion(spec.MaxTLSVersion)
+}
+
+// validateSubtests rejects subtests whose expected version cannot be
+// negotiated, such subtests would always be reported as intercepted.
+func (c *Config) validateSubtests() error {
+	for _, spec := range c.Subtests {
+		if spec.ExpectedVersion == 0 {
+			continue
+		}
+		if version := negotiatedTLSVersion(spec.MaxTLSVersion); spec.ExpectedVersion != version {
+			return fmt.Errorf("subtest %d: ExpectedVersion %#04x cannot be negotiated, the TLS library negotiates %#04x",
+				spec.Number, spec.ExpectedVersion, version)
+		}
+	}
+	return nil
 }
 
 var defaultConfig = Config{
-	MutableTestPeriodSecs: 15 * 60,
+	MutableTestPeriodSecs:         15 * 60,
+	PendingTestExpiryIntervalSecs: 60,
+	EphemeralTestTTLSecs:          30 * 60,
 
 	Subtests: []SubtestSpec{
 		{Number: 1, MaxTLSVersion: tls.VersionTLS12, IsIPv6: false},
 		{Number: 2, MaxTLSVersion: tls.VersionTLS12, IsIPv6: true},
-		{Number: 3, MaxTLSVersion: tls.VersionTLS13, IsIPv6: false},
-		{Number: 4, MaxTLSVersion: tls.VersionTLS13, IsIPv6: true},
+		{Number: 3, MaxTLSVersion: tls.VersionTLS13, IsIPv6: false, ExpectedVersion: versionTLS13Draft22},
+		{Number: 4, MaxTLSVersion: tls.VersionTLS13, IsIPv6: true, ExpectedVersion: versionTLS13Draft22},
 	},
 
 	DatabaseConnInfo: "sslmode=disable",
@@ -88,15 +192,50 @@ var defaultConfig = Config{
 	ReporterPrivateKey:  "reporter.key",
 	DummyCertificate:    "dummy.crt",
 	DummyPrivateKey:     "dummy.key",
+
+	CreateTestRateLimit: RateLimit{PerMinute: 6, Burst: 10},
+	SubmitRateLimit:     RateLimit{PerMinute: 60, Burst: 30},
+
+	RetentionIntervalSecs: 60 * 60,
+	RetentionBatchSize:    1000,
 }
 
 // (Partially) updates the configuration from the given file.
 func (c *Config) Update(filename string) error {
-	file, err := os.Open(filename)
+	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	dec := json.NewDecoder(file)
-	return dec.Decode(c)
+	if err = json.Unmarshal(data, c); err != nil {
+		return err
+	}
+	if err = c.migrateLegacySettings(data); err != nil {
+		return err
+	}
+	return c.validateSubtests()
+}
+
+// legacyConfig contains settings that have been replaced.
+type legacyConfig struct {
+	// Replaced by ReporterApiKeys.
+	ReporterApiKeyHash string
+}
+
+// migrateLegacySettings converts replaced settings from a configuration file.
+// The former single API key granted access to all privileged endpoints.
+func (c *Config) migrateLegacySettings(data []byte) error {
+	var legacy legacyConfig
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+	if legacy.ReporterApiKeyHash != "" {
+		log.Printf("ReporterApiKeyHash is deprecated, using it as write key %q. Please configure ReporterApiKeys instead.",
+			legacyApiKeyName)
+		c.ReporterApiKeys = append(c.ReporterApiKeys, ApiKey{
+			Name: legacyApiKeyName,
+			Hash: legacy.ReporterApiKeyHash,
+			Role: roleWrite,
+		})
+	}
+	return nil
 }
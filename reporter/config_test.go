@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMigrateLegacySettings(t *testing.T) {
+	config := defaultConfig
+	config.ReporterApiKeys = []ApiKey{{Name: "alice", Hash: "aa", Role: roleRead}}
+	if err := config.migrateLegacySettings([]byte(`{"ReporterApiKeyHash": "bb"}`)); err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	if len(config.ReporterApiKeys) != 2 {
+		t.Fatalf("expected two keys, got %v", config.ReporterApiKeys)
+	}
+	key := config.ReporterApiKeys[1]
+	if key.Name != legacyApiKeyName || key.Hash != "bb" || key.Role != roleWrite {
+		t.Errorf("unexpected migrated key: %v", key)
+	}
+
+	config.ReporterApiKeys = nil
+	if err := config.migrateLegacySettings([]byte(`{"ListenAddress": ":443"}`)); err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	if len(config.ReporterApiKeys) != 0 {
+		t.Errorf("unexpected keys: %v", config.ReporterApiKeys)
+	}
+}
+
+func TestValidateSubtests(t *testing.T) {
+	config := defaultConfig
+	if err := config.validateSubtests(); err != nil {
+		t.Errorf("default subtests are invalid: %v", err)
+	}
+	for _, spec := range []SubtestSpec{
+		{Number: 1, MaxTLSVersion: 0x0304, ExpectedVersion: 0x0304},
+		{Number: 1, MaxTLSVersion: 0x0304, ExpectedVersion: 0x7f17},
+		{Number: 1, MaxTLSVersion: 0x0303, ExpectedVersion: versionTLS13Draft22},
+	} {
+		config.Subtests = []SubtestSpec{spec}
+		if err := config.validateSubtests(); err == nil {
+			t.Errorf("expected error for %+v", spec)
+		}
+	}
+}
@@ -2,6 +2,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"io"
 	"net"
 	"sync"
@@ -66,13 +67,21 @@ func (c *serverCaptureConn) Close() error {
 	err := c.CaptureConn.Close()
 	if c.CaptureConn.StopCapture() {
 		c.info.EndTime = time.Now().UTC()
+		params := parseHandshakeParams(c.info.Frames, false)
+		c.info.KeyExchangeGroup = params.KeyExchangeGroup
+		c.info.HelloRetryRequest = params.HelloRetryRequest
 		c.ServerCaptureReady(c.name, c.info)
 	}
 	return err
 }
 
-func (c *serverCaptureConn) SetActualTLSVersion(version uint16) {
-	c.info.ActualTLSVersion = version
+// SetConnectionState records the negotiated parameters after a successful
+// handshake.
+func (c *serverCaptureConn) SetConnectionState(state *tls.ConnectionState) {
+	c.info.ActualTLSVersion = state.Version
+	c.info.CipherSuite = state.CipherSuite
+	c.info.ALPNProtocol = state.NegotiatedProtocol
+	c.info.IsResumed = state.DidResume
 	c.info.HasFailed = false
 }
 
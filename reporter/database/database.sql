@@ -12,6 +12,7 @@ CREATE TABLE tests (
 	has_failed          boolean     NOT NULL,
 	is_mitm             boolean     NOT NULL,
 	is_pending          boolean     NOT NULL,
+	deletion_token_hash text        NOT NULL,
 	UNIQUE (test_id)
 );
 CREATE TABLE subtests (
@@ -22,6 +23,12 @@ CREATE TABLE subtests (
 	is_ipv6             boolean     NOT NULL,
 	has_failed          boolean     NOT NULL,
 	is_mitm             boolean     NOT NULL,
+	mitm_reason         text        NOT NULL,
+	expected_version    integer     NOT NULL,
+	no_client_result    boolean     NOT NULL,
+	extra_connections   integer     NOT NULL,
+	middlebox_vendor    text        NOT NULL,
+	downgrade_verdict   text        NOT NULL,
 	UNIQUE (test_id, number)
 );
 CREATE TABLE client_captures (
@@ -34,6 +41,16 @@ CREATE TABLE client_captures (
 	frames              jsonb       NOT NULL,
 	key_log             text        NOT NULL,
 	has_failed          boolean     NOT NULL,
+	cipher_suite        integer     NOT NULL,
+	key_exchange_group  integer     NOT NULL,
+	alpn_protocol       text        NOT NULL,
+	is_resumed          boolean     NOT NULL,
+	hello_retry_request boolean     NOT NULL,
+	peer_certificates   jsonb       NOT NULL,
+	spki_hashes         jsonb       NOT NULL,
+	cert_substituted    boolean     NOT NULL,
+	pin_status          text        NOT NULL,
+	downgrade_sentinel  boolean     NOT NULL,
 	UNIQUE (subtest_id)
 );
 CREATE TABLE server_captures (
@@ -46,7 +63,26 @@ CREATE TABLE server_captures (
 	frames              jsonb       NOT NULL,
 	key_log             text        NOT NULL,
 	has_failed          boolean     NOT NULL,
+	cipher_suite        integer     NOT NULL,
+	key_exchange_group  integer     NOT NULL,
+	alpn_protocol       text        NOT NULL,
+	is_resumed          boolean     NOT NULL,
+	hello_retry_request boolean     NOT NULL,
 	client_ip           inet        NOT NULL,
 	server_ip           inet        NOT NULL,
-	UNIQUE (subtest_id)
+	tls13_disabled      boolean     NOT NULL,
+	client_hello        jsonb       NOT NULL,
+	ja3_string          text        NOT NULL,
+	ja3_fingerprint     text        NOT NULL,
+	fingerprint         jsonb       NOT NULL
+);
+CREATE TABLE audit_logs (
+	id                  serial      PRIMARY KEY,
+	created_at          timestamp   NOT NULL,
+	api_key_name        text        NOT NULL,
+	method              text        NOT NULL,
+	path                text        NOT NULL,
+	test_id             text        NOT NULL,
+	client_ip           inet        NOT NULL,
+	status              integer     NOT NULL
 );
@@ -12,7 +12,8 @@ func main() {
 	makeTable(Test{}, "test_id")
 	makeTable(Subtest{}, "test_id, number")
 	makeTable(ClientCapture{}, "subtest_id")
-	makeTable(ServerCapture{}, "subtest_id")
+	makeTable(ServerCapture{}, "")
+	makeTable(AuditLog{}, "")
 }
 
 func snakeCase(name string) string {
@@ -56,7 +57,7 @@ func inferType(table, colName string, t reflect.Type) string {
 	switch colName {
 	case "id":
 		return "serial"
-	case "frames":
+	case "frames", "client_hello", "fingerprint", "peer_certificates", "spki_hashes":
 		return "jsonb"
 	case "test_id":
 		if table == "tests" {
@@ -79,10 +80,13 @@ func inferType(table, colName string, t reflect.Type) string {
 	}
 }
 
+// makeTable prints the table definition for the model. The unique requirement
+// is optional.
 func makeTable(i interface{}, uniqueRequirement string) {
 	t := reflect.TypeOf(i)
 	tableName := snakeCase(t.Name()) + "s"
 	fmt.Println("CREATE TABLE", tableName, "(")
+	var columns []string
 	for _, f := range getFields(t) {
 		colName := snakeCase(f.Name)
 		colType := inferType(tableName, colName, f.Type)
@@ -93,8 +97,8 @@ func makeTable(i interface{}, uniqueRequirement string) {
 			extras += " NOT NULL"
 		}
 
-		// foreign key magic
-		if strings.HasSuffix(colName, "_id") {
+		// foreign key magic (references are internal integer IDs)
+		if strings.HasSuffix(colName, "_id") && f.Type.Kind() == reflect.Int {
 			otherTable := colName[:len(colName)-3] + "s"
 			if otherTable != tableName {
 				colType = "integer"
@@ -103,8 +107,11 @@ func makeTable(i interface{}, uniqueRequirement string) {
 			}
 		}
 
-		fmt.Printf("\t%-19s %-11s%s,\n", colName, colType, extras)
+		columns = append(columns, fmt.Sprintf("\t%-19s %-11s%s", colName, colType, extras))
 	}
-	fmt.Printf("\tUNIQUE (%s)\n", uniqueRequirement)
+	if uniqueRequirement != "" {
+		columns = append(columns, fmt.Sprintf("\tUNIQUE (%s)", uniqueRequirement))
+	}
+	fmt.Println(strings.Join(columns, ",\n"))
 	fmt.Println(");")
 }
@@ -10,6 +10,7 @@ import (
 
 type Querier interface {
 	QueryRow(query string, args ...interface{}) *sql.Row
+	Query(query string, args ...interface{}) (*sql.Rows, error)
 }
 
 // Create a new Test model. Required field: ClientIP. Fields that are updated:
@@ -33,7 +34,8 @@ func (model *Test) Create(tx *sql.Tx) error {
 		user_comment,
 		has_failed,
 		is_mitm,
-		is_pending
+		is_pending,
+		deletion_token_hash
 	) VALUES (
 		--     -- id
 		$1,    -- test_id
@@ -46,7 +48,8 @@ func (model *Test) Create(tx *sql.Tx) error {
 		$6,    -- user_comment
 		$7,    -- has_failed,
 		$8,    -- is_mitm,
-		$9     -- is_pending
+		$9,    -- is_pending
+		$10    -- deletion_token_hash
 	) RETURNING
 		id, created_at, updated_at
 	`,
@@ -62,6 +65,7 @@ func (model *Test) Create(tx *sql.Tx) error {
 		&model.HasFailed,
 		&model.IsMitm,
 		&model.IsPending,
+		&model.DeletionTokenHash,
 	).Scan(
 		&model.ID,
 		&model.CreatedAt,
@@ -72,14 +76,9 @@ func (model *Test) Create(tx *sql.Tx) error {
 
 // Query the tests model
 func QueryTests(db *sql.DB, args ...interface{}) (*sql.Rows, error) {
-	extraQuery := ""
-	if len(args) > 0 {
-		var ok bool
-		extraQuery, ok = args[0].(string)
-		if !ok {
-			return nil, errors.New("argument must be a SQL string")
-		}
-		args = args[1:]
+	extraQuery, args, err := splitExtraQuery(args)
+	if err != nil {
+		return nil, err
 	}
 	rows, err := db.Query(`
 	SELECT
@@ -94,7 +93,8 @@ func QueryTests(db *sql.DB, args ...interface{}) (*sql.Rows, error) {
 		user_comment,
 		has_failed,
 		is_mitm,
-		is_pending
+		is_pending,
+		deletion_token_hash
 	FROM tests
 	`+extraQuery, args...)
 	return rows, err
@@ -117,6 +117,7 @@ func ScanTest(rows *sql.Rows) (*Test, error) {
 		&model.HasFailed,
 		&model.IsMitm,
 		&model.IsPending,
+		&model.DeletionTokenHash,
 	)
 	if err != nil {
 		return nil, err
@@ -139,7 +140,13 @@ func (model *Subtest) Create(tx *sql.Tx) error {
 		max_tls_version,
 		is_ipv6,
 		has_failed,
-		is_mitm
+		is_mitm,
+		mitm_reason,
+		no_client_result,
+		extra_connections,
+		middlebox_vendor,
+		downgrade_verdict,
+		expected_version
 	) VALUES (
 		--              -- id
 		$1,             -- test_id
@@ -147,7 +154,13 @@ func (model *Subtest) Create(tx *sql.Tx) error {
 		$3,             -- max_tls_version
 		$4,             -- is_ipv6
 		$5,             -- has_failed
-		$6              -- is_mitm
+		$6,             -- is_mitm
+		$7,             -- mitm_reason
+		$8,             -- no_client_result
+		$9,             -- extra_connections
+		$10,            -- middlebox_vendor
+		$11,            -- downgrade_verdict
+		$12             -- expected_version
 	) RETURNING
 		id
 	`,
@@ -158,6 +171,12 @@ func (model *Subtest) Create(tx *sql.Tx) error {
 		&model.IsIPv6,
 		&model.HasFailed,
 		&model.IsMitm,
+		&model.MitmReason,
+		&model.NoClientResult,
+		&model.ExtraConnections,
+		&model.MiddleboxVendor,
+		&model.DowngradeVerdict,
+		&model.ExpectedVersion,
 	).Scan(
 		&model.ID,
 	)
@@ -177,6 +196,14 @@ func (model *ClientCapture) Create(querier Querier) error {
 	if err != nil {
 		return err
 	}
+	peerCertificates, err := json.Marshal(model.PeerCertificates)
+	if err != nil {
+		return err
+	}
+	spkiHashes, err := json.Marshal(model.SPKIHashes)
+	if err != nil {
+		return err
+	}
 	err = querier.QueryRow(`
 	INSERT INTO client_captures (
 		-- id,
@@ -187,7 +214,17 @@ func (model *ClientCapture) Create(querier Querier) error {
 		actual_tls_version,
 		frames,
 		key_log,
-		has_failed
+		has_failed,
+		cipher_suite,
+		key_exchange_group,
+		alpn_protocol,
+		is_resumed,
+		hello_retry_request,
+		peer_certificates,
+		spki_hashes,
+		cert_substituted,
+		pin_status,
+		downgrade_sentinel
 	) VALUES (
 		--              -- id,
 		$1,             -- subtest_id,
@@ -197,7 +234,17 @@ func (model *ClientCapture) Create(querier Querier) error {
 		$4,             -- actual_tls_version,
 		$5,             -- frames,
 		$6,             -- key_log,
-		$7              -- has_failed
+		$7,             -- has_failed,
+		$8,             -- cipher_suite,
+		$9,             -- key_exchange_group,
+		$10,            -- alpn_protocol,
+		$11,            -- is_resumed,
+		$12,            -- hello_retry_request,
+		$13,            -- peer_certificates,
+		$14,            -- spki_hashes,
+		$15,            -- cert_substituted,
+		$16,            -- pin_status,
+		$17             -- downgrade_sentinel
 	) RETURNING
 		id,
 		created_at
@@ -211,6 +258,16 @@ func (model *ClientCapture) Create(querier Querier) error {
 		&frames,
 		&model.KeyLog,
 		&model.HasFailed,
+		&model.CipherSuite,
+		&model.KeyExchangeGroup,
+		&model.ALPNProtocol,
+		&model.IsResumed,
+		&model.HelloRetryRequest,
+		&peerCertificates,
+		&spkiHashes,
+		&model.CertSubstituted,
+		&model.PinStatus,
+		&model.DowngradeSentinel,
 	).Scan(
 		&model.ID,
 		&model.CreatedAt,
@@ -239,6 +296,15 @@ func (model *ServerCapture) Create(querier Querier) error {
 	if err != nil {
 		return err
 	}
+	// a missing ClientHello is stored as JSON null.
+	clientHello, err := json.Marshal(model.ClientHello)
+	if err != nil {
+		return err
+	}
+	fingerprint, err := json.Marshal(model.Fingerprint)
+	if err != nil {
+		return err
+	}
 	err = querier.QueryRow(`
 	INSERT INTO server_captures (
 		-- id,
@@ -250,8 +316,18 @@ func (model *ServerCapture) Create(querier Querier) error {
 		frames,
 		key_log,
 		has_failed,
+		cipher_suite,
+		key_exchange_group,
+		alpn_protocol,
+		is_resumed,
+		hello_retry_request,
 		client_ip,
-		server_ip
+		server_ip,
+		tls13_disabled,
+		client_hello,
+		ja3_string,
+		ja3_fingerprint,
+		fingerprint
 	) VALUES (
 		--              -- id,
 		$1,             -- subtest_id,
@@ -262,8 +338,18 @@ func (model *ServerCapture) Create(querier Querier) error {
 		$5,             -- frames,
 		$6,             -- key_log,
 		$7,             -- has_failed,
-		$8,             -- client_ip,
-		$9              -- server_ip
+		$8,             -- cipher_suite,
+		$9,             -- key_exchange_group,
+		$10,            -- alpn_protocol,
+		$11,            -- is_resumed,
+		$12,            -- hello_retry_request,
+		$13,            -- client_ip,
+		$14,            -- server_ip,
+		$15,            -- tls13_disabled,
+		$16,            -- client_hello,
+		$17,            -- ja3_string,
+		$18,            -- ja3_fingerprint,
+		$19             -- fingerprint
 	) RETURNING
 		id,
 		created_at
@@ -277,8 +363,18 @@ func (model *ServerCapture) Create(querier Querier) error {
 		&frames,
 		&model.KeyLog,
 		&model.HasFailed,
+		&model.CipherSuite,
+		&model.KeyExchangeGroup,
+		&model.ALPNProtocol,
+		&model.IsResumed,
+		&model.HelloRetryRequest,
 		&clientIP,
 		&serverIP,
+		&model.TLS13Disabled,
+		&clientHello,
+		&model.JA3String,
+		&model.JA3Fingerprint,
+		&fingerprint,
 	).Scan(
 		&model.ID,
 		&model.CreatedAt,
@@ -307,3 +403,395 @@ func QuerySubtest(db *sql.DB, testID string, number int, mutableTestPeriodSecs i
 	}
 	return subtestID, nil
 }
+
+// splitExtraQuery separates an optional leading SQL string from the arguments.
+func splitExtraQuery(args []interface{}) (string, []interface{}, error) {
+	if len(args) == 0 {
+		return "", args, nil
+	}
+	extraQuery, ok := args[0].(string)
+	if !ok {
+		return "", nil, errors.New("argument must be a SQL string")
+	}
+	return extraQuery, args[1:], nil
+}
+
+// Query the subtests model. Columns are qualified such that the optional extra
+// query can join other tables.
+func QuerySubtests(querier Querier, args ...interface{}) (*sql.Rows, error) {
+	extraQuery, args, err := splitExtraQuery(args)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := querier.Query(`
+	SELECT
+		subtests.id,
+		subtests.test_id,
+		subtests.number,
+		subtests.max_tls_version,
+		subtests.is_ipv6,
+		subtests.has_failed,
+		subtests.is_mitm,
+		subtests.mitm_reason,
+		subtests.no_client_result,
+		subtests.extra_connections,
+		subtests.middlebox_vendor,
+		subtests.downgrade_verdict,
+		subtests.expected_version
+	FROM subtests
+	`+extraQuery, args...)
+	return rows, err
+}
+
+// Populates a Subtest model instance from the result set by scanning it.
+func ScanSubtest(rows *sql.Rows) (*Subtest, error) {
+	model := new(Subtest)
+	err := rows.Scan(
+		&model.ID,
+		&model.TestID,
+		&model.Number,
+		&model.MaxTLSVersion,
+		&model.IsIPv6,
+		&model.HasFailed,
+		&model.IsMitm,
+		&model.MitmReason,
+		&model.NoClientResult,
+		&model.ExtraConnections,
+		&model.MiddleboxVendor,
+		&model.DowngradeVerdict,
+		&model.ExpectedVersion,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// Query the client captures model. Columns are qualified such that the
+// optional extra query can join other tables.
+func QueryClientCaptures(querier Querier, args ...interface{}) (*sql.Rows, error) {
+	extraQuery, args, err := splitExtraQuery(args)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := querier.Query(`
+	SELECT
+		client_captures.id,
+		client_captures.subtest_id,
+		client_captures.created_at,
+		client_captures.begin_time,
+		client_captures.end_time,
+		client_captures.actual_tls_version,
+		client_captures.frames,
+		client_captures.key_log,
+		client_captures.has_failed,
+		client_captures.cipher_suite,
+		client_captures.key_exchange_group,
+		client_captures.alpn_protocol,
+		client_captures.is_resumed,
+		client_captures.hello_retry_request,
+		client_captures.peer_certificates,
+		client_captures.spki_hashes,
+		client_captures.cert_substituted,
+		client_captures.pin_status,
+		client_captures.downgrade_sentinel
+	FROM client_captures
+	`+extraQuery, args...)
+	return rows, err
+}
+
+// Populates a ClientCapture model instance from the result set by scanning it.
+func ScanClientCapture(rows *sql.Rows) (*ClientCapture, error) {
+	model := new(ClientCapture)
+	var frames, peerCertificates, spkiHashes []byte
+	err := rows.Scan(
+		&model.ID,
+		&model.SubtestID,
+		&model.CreatedAt,
+		&model.BeginTime,
+		&model.EndTime,
+		&model.ActualTLSVersion,
+		&frames,
+		&model.KeyLog,
+		&model.HasFailed,
+		&model.CipherSuite,
+		&model.KeyExchangeGroup,
+		&model.ALPNProtocol,
+		&model.IsResumed,
+		&model.HelloRetryRequest,
+		&peerCertificates,
+		&spkiHashes,
+		&model.CertSubstituted,
+		&model.PinStatus,
+		&model.DowngradeSentinel,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(frames, &model.Frames); err != nil {
+		return nil, fmt.Errorf("Could not parse frames: %v", err)
+	}
+	if err = json.Unmarshal(peerCertificates, &model.PeerCertificates); err != nil {
+		return nil, fmt.Errorf("Could not parse peer certificates: %v", err)
+	}
+	if err = json.Unmarshal(spkiHashes, &model.SPKIHashes); err != nil {
+		return nil, fmt.Errorf("Could not parse SPKI hashes: %v", err)
+	}
+	return model, nil
+}
+
+// Query the server captures model. Columns are qualified such that the
+// optional extra query can join other tables.
+func QueryServerCaptures(querier Querier, args ...interface{}) (*sql.Rows, error) {
+	extraQuery, args, err := splitExtraQuery(args)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := querier.Query(`
+	SELECT
+		server_captures.id,
+		server_captures.subtest_id,
+		server_captures.created_at,
+		server_captures.begin_time,
+		server_captures.end_time,
+		server_captures.actual_tls_version,
+		server_captures.frames,
+		server_captures.key_log,
+		server_captures.has_failed,
+		server_captures.cipher_suite,
+		server_captures.key_exchange_group,
+		server_captures.alpn_protocol,
+		server_captures.is_resumed,
+		server_captures.hello_retry_request,
+		server_captures.client_ip,
+		server_captures.server_ip,
+		server_captures.tls13_disabled,
+		server_captures.client_hello,
+		server_captures.ja3_string,
+		server_captures.ja3_fingerprint,
+		server_captures.fingerprint
+	FROM server_captures
+	`+extraQuery, args...)
+	return rows, err
+}
+
+// Populates a ServerCapture model instance from the result set by scanning it.
+func ScanServerCapture(rows *sql.Rows) (*ServerCapture, error) {
+	model := new(ServerCapture)
+	var frames, clientIP, serverIP, clientHello, fingerprint []byte
+	err := rows.Scan(
+		&model.ID,
+		&model.SubtestID,
+		&model.CreatedAt,
+		&model.BeginTime,
+		&model.EndTime,
+		&model.ActualTLSVersion,
+		&frames,
+		&model.KeyLog,
+		&model.HasFailed,
+		&model.CipherSuite,
+		&model.KeyExchangeGroup,
+		&model.ALPNProtocol,
+		&model.IsResumed,
+		&model.HelloRetryRequest,
+		&clientIP,
+		&serverIP,
+		&model.TLS13Disabled,
+		&clientHello,
+		&model.JA3String,
+		&model.JA3Fingerprint,
+		&fingerprint,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(frames, &model.Frames); err != nil {
+		return nil, fmt.Errorf("Could not parse frames: %v", err)
+	}
+	if err = json.Unmarshal(clientHello, &model.ClientHello); err != nil {
+		return nil, fmt.Errorf("Could not parse client hello: %v", err)
+	}
+	if err = json.Unmarshal(fingerprint, &model.Fingerprint); err != nil {
+		return nil, fmt.Errorf("Could not parse fingerprint: %v", err)
+	}
+	model.ClientIP = net.ParseIP(string(clientIP))
+	if model.ClientIP == nil {
+		return nil, fmt.Errorf("Could not parse client IP: %v", clientIP)
+	}
+	model.ServerIP = net.ParseIP(string(serverIP))
+	if model.ServerIP == nil {
+		return nil, fmt.Errorf("Could not parse server IP: %v", serverIP)
+	}
+	return model, nil
+}
+
+// SubtestResult combines a subtest with the captures that were recorded for it.
+type SubtestResult struct {
+	*Subtest
+	ClientCapture *ClientCapture
+	// Server captures, ordered from first to last.
+	ServerCaptures []*ServerCapture
+}
+
+// QuerySubtestResults retrieves all subtests (ordered by number) for the given
+// internal TestID together with their client and server captures.
+func QuerySubtestResults(querier Querier, testID int) ([]*SubtestResult, error) {
+	var results []*SubtestResult
+	bySubtestID := make(map[int]*SubtestResult)
+
+	rows, err := QuerySubtests(querier, `
+	WHERE subtests.test_id = $1
+	ORDER BY subtests.number
+	`, testID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		subtest, err := ScanSubtest(rows)
+		if err != nil {
+			return nil, err
+		}
+		result := &SubtestResult{Subtest: subtest}
+		results = append(results, result)
+		bySubtestID[subtest.ID] = result
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	clientRows, err := QueryClientCaptures(querier, `
+	JOIN subtests ON subtests.id = client_captures.subtest_id
+	WHERE subtests.test_id = $1
+	`, testID)
+	if err != nil {
+		return nil, err
+	}
+	defer clientRows.Close()
+	for clientRows.Next() {
+		capture, err := ScanClientCapture(clientRows)
+		if err != nil {
+			return nil, err
+		}
+		if result := bySubtestID[capture.SubtestID]; result != nil {
+			result.ClientCapture = capture
+		}
+	}
+	if err = clientRows.Err(); err != nil {
+		return nil, err
+	}
+
+	serverRows, err := QueryServerCaptures(querier, `
+	JOIN subtests ON subtests.id = server_captures.subtest_id
+	WHERE subtests.test_id = $1
+	ORDER BY server_captures.begin_time, server_captures.id
+	`, testID)
+	if err != nil {
+		return nil, err
+	}
+	defer serverRows.Close()
+	for serverRows.Next() {
+		capture, err := ScanServerCapture(serverRows)
+		if err != nil {
+			return nil, err
+		}
+		if result := bySubtestID[capture.SubtestID]; result != nil {
+			result.ServerCaptures = append(result.ServerCaptures, capture)
+		}
+	}
+	if err = serverRows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Create a new AuditLog model. Required field: ClientIP. Fields that are
+// updated: ID, CreatedAt.
+func (model *AuditLog) Create(querier Querier) error {
+	if model.ClientIP == nil {
+		return errors.New("client IP must be initialized")
+	}
+	clientIP := model.ClientIP.String()
+	err := querier.QueryRow(`
+	INSERT INTO audit_logs (
+		-- id,
+		created_at,
+		api_key_name,
+		method,
+		path,
+		test_id,
+		client_ip,
+		status
+	) VALUES (
+		--              -- id,
+		now(),          -- created_at,
+		$1,             -- api_key_name,
+		$2,             -- method,
+		$3,             -- path,
+		$4,             -- test_id,
+		$5,             -- client_ip,
+		$6              -- status
+	) RETURNING
+		id,
+		created_at
+	`,
+		//&model.ID,
+		//&model.CreatedAt,
+		&model.ApiKeyName,
+		&model.Method,
+		&model.Path,
+		&model.TestID,
+		&clientIP,
+		&model.Status,
+	).Scan(
+		&model.ID,
+		&model.CreatedAt,
+	)
+	return err
+}
+
+// Query the audit log model.
+func QueryAuditLogs(querier Querier, args ...interface{}) (*sql.Rows, error) {
+	extraQuery, args, err := splitExtraQuery(args)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := querier.Query(`
+	SELECT
+		id,
+		created_at,
+		api_key_name,
+		method,
+		path,
+		test_id,
+		client_ip,
+		status
+	FROM audit_logs
+	`+extraQuery, args...)
+	return rows, err
+}
+
+// Populates an AuditLog model instance from the result set by scanning it.
+func ScanAuditLog(rows *sql.Rows) (*AuditLog, error) {
+	model := new(AuditLog)
+	var clientIP []byte
+	err := rows.Scan(
+		&model.ID,
+		&model.CreatedAt,
+		&model.ApiKeyName,
+		&model.Method,
+		&model.Path,
+		&model.TestID,
+		&clientIP,
+		&model.Status,
+	)
+	if err != nil {
+		return nil, err
+	}
+	model.ClientIP = net.ParseIP(string(clientIP))
+	if model.ClientIP == nil {
+		return nil, fmt.Errorf("Could not parse client IP: %v", clientIP)
+	}
+	return model, nil
+}
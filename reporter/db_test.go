@@ -55,3 +55,129 @@ func TestTestCreate(t *testing.T) {
 		t.Errorf("Rollback failed: %v", err)
 	}
 }
+
+func TestFinalizeTest(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration tests")
+		return
+	}
+	db := testDB()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Transaction start failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	m := &Test{
+		ClientIP:  net.ParseIP("::"),
+		IsPending: true,
+	}
+	if err = m.Create(tx); err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	subtest := &Subtest{
+		TestID: m.ID,
+		Number: 1,
+	}
+	if err = subtest.Create(tx); err != nil {
+		t.Fatalf("Subtest query failed: %v", err)
+	}
+
+	missing, err := countMissingClientResults(tx, m.ID)
+	if err != nil || missing != 1 {
+		t.Errorf("expected one missing result, got %d (%v)", missing, err)
+	}
+	clientCapture := &ClientCapture{Capture: Capture{
+		SubtestID: subtest.ID,
+		Frames:    []Frame{},
+		HasFailed: true,
+	}}
+	if err = clientCapture.Create(tx); err != nil {
+		t.Fatalf("Client capture query failed: %v", err)
+	}
+	missing, err = countMissingClientResults(tx, m.ID)
+	if err != nil || missing != 0 {
+		t.Errorf("expected no missing results, got %d (%v)", missing, err)
+	}
+
+	if err = FinalizeTest(tx, m.ID, defaultTestFinalizers); err != nil {
+		t.Fatalf("FinalizeTest failed: %v", err)
+	}
+	var isPending, hasFailed bool
+	err = tx.QueryRow(`SELECT is_pending, has_failed FROM tests WHERE id = $1`, m.ID).Scan(&isPending, &hasFailed)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if isPending || !hasFailed {
+		t.Errorf("expected concluded, failed test, got is_pending=%t has_failed=%t", isPending, hasFailed)
+	}
+}
+
+func TestServerCaptureAfterConclusion(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration tests")
+		return
+	}
+	db := testDB()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Transaction start failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	m := &Test{
+		ClientIP:  net.ParseIP("::"),
+		IsPending: true,
+	}
+	if err = m.Create(tx); err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	subtest := &Subtest{
+		TestID: m.ID,
+		Number: 1,
+	}
+	if err = subtest.Create(tx); err != nil {
+		t.Fatalf("Subtest query failed: %v", err)
+	}
+	clientCapture := &ClientCapture{Capture: Capture{
+		SubtestID:        subtest.ID,
+		Frames:           []Frame{},
+		ActualTLSVersion: 0x0303,
+	}}
+	if err = clientCapture.Create(tx); err != nil {
+		t.Fatalf("Client capture query failed: %v", err)
+	}
+	if err = FinalizeTest(tx, m.ID, defaultTestFinalizers); err != nil {
+		t.Fatalf("FinalizeTest failed: %v", err)
+	}
+
+	queryVerdict := func() (bool, string) {
+		var isMitm bool
+		var reason string
+		err := tx.QueryRow(`SELECT is_mitm, mitm_reason FROM subtests WHERE id = $1`, subtest.ID).Scan(&isMitm, &reason)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		return isMitm, reason
+	}
+	if isMitm, reason := queryVerdict(); !isMitm || reason != mitmReasonMissingServerCapture {
+		t.Errorf("expected missing server capture, got is_mitm=%t reason=%q", isMitm, reason)
+	}
+
+	// the server capture completes after the test was concluded.
+	serverCapture := &ServerCapture{
+		Capture: Capture{
+			SubtestID:        subtest.ID,
+			Frames:           []Frame{},
+			ActualTLSVersion: 0x0303,
+		},
+		ClientIP: net.ParseIP("::1"),
+		ServerIP: net.ParseIP("::1"),
+	}
+	if err = addServerCapture(tx, serverCapture, defaultTestFinalizers); err != nil {
+		t.Fatalf("addServerCapture failed: %v", err)
+	}
+	if isMitm, reason := queryVerdict(); isMitm || reason != "" {
+		t.Errorf("expected no MITM, got is_mitm=%t reason=%q", isMitm, reason)
+	}
+}
@@ -0,0 +1,295 @@
+// In-memory storage for anonymous ("otr-") tests. Nothing is written to the
+// database, results are forgotten after a while.
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Prefix of the TestID of anonymous tests.
+const ephemeralTestPrefix = "otr-"
+
+// Pseudo SubtestID for server captures of anonymous tests.
+const ephemeralSubtestID = -1
+
+// Limits to bound memory usage.
+const (
+	maxEphemeralTests          = 10000
+	maxEphemeralServerCaptures = 10
+)
+
+var (
+	errEphemeralStoreFull    = errors.New("too many anonymous tests")
+	errEphemeralTestNotFound = errors.New("test not found")
+	errDuplicateClientResult = errors.New("test submission was already received")
+)
+
+// isEphemeralTestID returns true for a TestID of an anonymous test.
+func isEphemeralTestID(testID string) bool {
+	return strings.HasPrefix(testID, ephemeralTestPrefix) &&
+		ValidateUUID(testID[len(ephemeralTestPrefix):])
+}
+
+type ephemeralTest struct {
+	createdAt time.Time
+	clientIP  net.IP
+	subtests  []*SubtestResult
+	// number of connections per subtest number whose server capture is
+	// not complete yet.
+	openServerCaptures map[int]int
+}
+
+// subtest returns the result for the subtest number or nil if unknown.
+func (t *ephemeralTest) subtest(number int) *SubtestResult {
+	for _, result := range t.subtests {
+		if result.Number == number {
+			return result
+		}
+	}
+	return nil
+}
+
+// ephemeralStore holds the captures of anonymous tests until they expire.
+type ephemeralStore struct {
+	ttl time.Duration
+
+	lock        sync.Mutex
+	tests       map[string]*ephemeralTest
+	lastCleanup time.Time
+	now         func() time.Time
+}
+
+func newEphemeralStore(ttl time.Duration) *ephemeralStore {
+	return &ephemeralStore{
+		ttl:   ttl,
+		tests: make(map[string]*ephemeralTest),
+		now:   time.Now,
+	}
+}
+
+// get returns the test unless it has expired. The lock must be held.
+func (s *ephemeralStore) get(testID string) *ephemeralTest {
+	now := s.now()
+	if now.Sub(s.lastCleanup) >= time.Minute {
+		for id, test := range s.tests {
+			if now.Sub(test.createdAt) >= s.ttl {
+				delete(s.tests, id)
+			}
+		}
+		s.lastCleanup = now
+	}
+	test := s.tests[testID]
+	if test == nil || now.Sub(test.createdAt) >= s.ttl {
+		return nil
+	}
+	return test
+}
+
+// Create registers a new anonymous test with the given subtests.
+func (s *ephemeralStore) Create(testID string, clientIP net.IP, specs []SubtestSpec) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	// expired tests are removed first, if needed.
+	if s.get(testID); len(s.tests) >= maxEphemeralTests {
+		return errEphemeralStoreFull
+	}
+	test := &ephemeralTest{
+		createdAt:          s.now(),
+		clientIP:           clientIP,
+		openServerCaptures: make(map[int]int),
+	}
+	for _, spec := range specs {
+		test.subtests = append(test.subtests, &SubtestResult{
+			Subtest: &Subtest{
+				Number:          spec.Number,
+				MaxTLSVersion:   spec.MaxTLSVersion,
+				IsIPv6:          spec.IsIPv6,
+				ExpectedVersion: spec.expectedTLSVersion(),
+			},
+		})
+	}
+	s.tests[testID] = test
+	return nil
+}
+
+// BeginServerCapture returns true if server captures are accepted for the
+// subtest. The capture must be passed to AddServerCapture once complete, until
+// then the subtest result is pending.
+func (s *ephemeralStore) BeginServerCapture(testID string, number int) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	test := s.get(testID)
+	if test == nil || test.subtest(number) == nil {
+		return false
+	}
+	test.openServerCaptures[number]++
+	return true
+}
+
+// AddServerCapture stores a server capture. Returns false if the test has
+// expired or if there are too many captures.
+func (s *ephemeralStore) AddServerCapture(testID string, number int, capture *ServerCapture) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	test := s.get(testID)
+	if test == nil {
+		return false
+	}
+	if test.openServerCaptures[number] > 0 {
+		test.openServerCaptures[number]--
+	}
+	result := test.subtest(number)
+	if result == nil || len(result.ServerCaptures) >= maxEphemeralServerCaptures {
+		return false
+	}
+	capture.CreatedAt = s.now().UTC()
+	result.ServerCaptures = append(result.ServerCaptures, capture)
+	return true
+}
+
+// AddClientCapture stores the client result of a subtest, it can be given once
+// by the client that created the test.
+func (s *ephemeralStore) AddClientCapture(testID string, clientIP net.IP, number int, capture *ClientCapture) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	test := s.get(testID)
+	if test == nil || !test.clientIP.Equal(clientIP) {
+		return errEphemeralTestNotFound
+	}
+	result := test.subtest(number)
+	if result == nil {
+		return errEphemeralTestNotFound
+	}
+	if result.ClientCapture != nil {
+		return errDuplicateClientResult
+	}
+	capture.CreatedAt = s.now().UTC()
+	result.ClientCapture = capture
+	return nil
+}
+
+// Results returns a snapshot of the subtest results if the test exists and was
+// created by the given client. The subtest verdicts are computed, except for
+// subtests with connections whose server capture is not complete yet. The
+// numbers of those pending subtests are returned as well.
+func (s *ephemeralStore) Results(testID string, clientIP net.IP) ([]*SubtestResult, []int, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	test := s.get(testID)
+	if test == nil || !test.clientIP.Equal(clientIP) {
+		return nil, nil, false
+	}
+	pending := []int{}
+	results := make([]*SubtestResult, len(test.subtests))
+	for i, stored := range test.subtests {
+		subtest := *stored.Subtest
+		result := &SubtestResult{
+			Subtest:        &subtest,
+			ClientCapture:  stored.ClientCapture,
+			ServerCaptures: append([]*ServerCapture(nil), stored.ServerCaptures...),
+		}
+		// captures are added once complete, order them like stored ones.
+		sort.SliceStable(result.ServerCaptures, func(i, j int) bool {
+			return result.ServerCaptures[i].BeginTime.Before(result.ServerCaptures[j].BeginTime)
+		})
+		results[i] = result
+		if test.openServerCaptures[subtest.Number] > 0 {
+			// the verdict would lack the server capture.
+			pending = append(pending, subtest.Number)
+			continue
+		}
+		concludeSubtestResult(result)
+	}
+	return results, pending, true
+}
+
+// concludeSubtestResult sets the summary fields of a subtest like the test
+// finalizers do for stored tests.
+func concludeSubtestResult(result *SubtestResult) {
+	subtest := result.Subtest
+	subtest.NoClientResult = result.ClientCapture == nil
+	subtest.HasFailed = result.ClientCapture != nil && result.ClientCapture.HasFailed
+	for _, capture := range result.ServerCaptures {
+		subtest.HasFailed = subtest.HasFailed || capture.HasFailed
+	}
+	subtest.MitmReason = computeMitmVerdict(subtest.ExpectedVersion, result.ClientCapture, result.ServerCaptures)
+	subtest.IsMitm = subtest.MitmReason != ""
+	subtest.ExtraConnections = countExtraConnections(result.ServerCaptures)
+	subtest.DowngradeVerdict = computeDowngradeVerdict(subtest.MaxTLSVersion, result.ClientCapture, result.ServerCaptures)
+}
+
+func (r *reporter) addEphemeralClientResult(c *gin.Context) {
+	subtestNumber, ok := r.getSubtestNumber(c)
+	if !ok {
+		return
+	}
+
+	var json addClientResultRequest
+	if err := c.BindJSON(&json); err == nil {
+		clientCapture, err := addClientResultRequestToClientCapture(&json)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		r.checkPeerCertificates(clientCapture)
+		clientIP := net.ParseIP(parseHost(c.Request.RemoteAddr))
+		err = r.ephemeralTests.AddClientCapture(c.Param("testid"), clientIP, subtestNumber, clientCapture)
+		switch err {
+		case nil:
+			c.Status(http.StatusNoContent)
+		case errDuplicateClientResult:
+			c.JSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+		default:
+			c.JSON(http.StatusNotFound, errSubTestNotFound)
+		}
+	}
+}
+
+// getEphemeralResult returns the server-side view and verdict of an anonymous
+// test to the client that created it.
+func (r *reporter) getEphemeralResult(c *gin.Context) {
+	testID := c.Param("testid")
+	clientIP := net.ParseIP(parseHost(c.Request.RemoteAddr))
+	var results []*SubtestResult
+	var pending []int
+	ok := false
+	if isEphemeralTestID(testID) {
+		results, pending, ok = r.ephemeralTests.Results(testID, clientIP)
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, errTestNotFound)
+		return
+	}
+
+	subtests := []*subtestWithCaptures{}
+	hasFailed, isMitm := false, false
+	for _, result := range results {
+		if r.middleboxes != nil {
+			result.MiddleboxVendor = r.middleboxes.Identify(result)
+		}
+		subtests = append(subtests, summarizeSubtestResult(result))
+		hasFailed = hasFailed || result.HasFailed
+		isMitm = isMitm || result.IsMitm
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"test_id":          testID,
+		"has_failed":       hasFailed,
+		"is_mitm":          isMitm,
+		"is_pending":       len(pending) > 0,
+		"pending_subtests": pending,
+		"subtests":         subtests,
+	})
+}
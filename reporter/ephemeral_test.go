@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseEphemeralTestHost(t *testing.T) {
+	config := &defaultConfig
+	testID := ephemeralTestPrefix + "6b5742d9-722b-4d12-848a-c42da771b806"
+	parsedID, number := parseTestHost(config, testID+"-3"+config.HostSuffixIPv6)
+	if parsedID != testID || number != 3 {
+		t.Errorf("unexpected result: %q, %d", parsedID, number)
+	}
+	if parsedID, _ := parseTestHost(config, "otr-nope-3"+config.HostSuffixIPv4); parsedID != "" {
+		t.Errorf("accepted invalid anonymous test host: %q", parsedID)
+	}
+}
+
+func TestEphemeralStore(t *testing.T) {
+	now := parseTime("2017-12-07T23:40:36Z")
+	store := newEphemeralStore(10 * time.Minute)
+	store.now = func() time.Time { return now }
+
+	testID := ephemeralTestPrefix + GenerateUUIDv4()
+	clientIP := net.ParseIP("192.0.2.1")
+	specs := []SubtestSpec{{Number: 1, MaxTLSVersion: 0x0303}}
+	if err := store.Create(testID, clientIP, specs); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if store.BeginServerCapture(testID, 2) {
+		t.Error("unknown subtest must not be accepted")
+	}
+	if !store.BeginServerCapture(testID, 1) {
+		t.Fatal("server capture was not accepted")
+	}
+	clientCapture := &ClientCapture{Capture: Capture{ActualTLSVersion: 0x0303}}
+	if err := store.AddClientCapture(testID, clientIP, 1, clientCapture); err != nil {
+		t.Errorf("client result was not accepted: %v", err)
+	}
+	// the server capture is not complete yet, no verdict is computed.
+	results, pending, ok := store.Results(testID, clientIP)
+	if !ok || len(pending) != 1 || pending[0] != 1 || results[0].IsMitm {
+		t.Errorf("expected pending subtest, got %v %+v", pending, results)
+	}
+
+	for i := 0; i < maxEphemeralServerCaptures; i++ {
+		if !store.AddServerCapture(testID, 1, &ServerCapture{}) {
+			t.Fatalf("server capture %d was not accepted", i+1)
+		}
+	}
+	if store.AddServerCapture(testID, 1, &ServerCapture{}) {
+		t.Error("too many server captures were accepted")
+	}
+
+	if err := store.AddClientCapture(testID, net.ParseIP("192.0.2.2"), 1, clientCapture); err == nil {
+		t.Error("client result from other client was accepted")
+	}
+	if err := store.AddClientCapture(testID, clientIP, 1, clientCapture); err != errDuplicateClientResult {
+		t.Errorf("expected duplicate error, got %v", err)
+	}
+
+	if _, _, ok := store.Results(testID, net.ParseIP("192.0.2.2")); ok {
+		t.Error("results must only be available to the same client")
+	}
+	results, pending, ok = store.Results(testID, clientIP)
+	if !ok || len(results) != 1 || len(pending) != 0 {
+		t.Fatalf("unexpected results: %v (pending %v)", results, pending)
+	}
+	if results[0].NoClientResult || len(results[0].ServerCaptures) != maxEphemeralServerCaptures {
+		t.Errorf("unexpected subtest result: %+v", results[0])
+	}
+
+	now = now.Add(10 * time.Minute)
+	if _, _, ok := store.Results(testID, clientIP); ok {
+		t.Error("expired test must be forgotten")
+	}
+	if len(store.tests) != 0 {
+		t.Error("expired test was not removed")
+	}
+}
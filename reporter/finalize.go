@@ -0,0 +1,257 @@
+// Conclusion of tests. Once a test no longer accepts changes, the results of
+// the subtests are summarized.
+package main
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// TestFinalizer is invoked within the transaction that concludes a test. The
+// internal TestID is given.
+type TestFinalizer func(tx *sql.Tx, testID int) error
+
+// Finalizers that run when a test is concluded, in order.
+var defaultTestFinalizers = []TestFinalizer{
+	updateMissingClientResults,
+	updateSubtestFailures,
+	updateSubtestVerdicts,
+	updateTestSummary,
+}
+
+// lockPendingTest locks the test for the remainder of the transaction and
+// returns whether it is still pending. This serializes concurrent submissions
+// such that only one of them concludes the test.
+func lockPendingTest(tx *sql.Tx, testID int) (bool, error) {
+	var isPending bool
+	err := tx.QueryRow(`
+	SELECT is_pending FROM tests WHERE id = $1 FOR UPDATE
+	`, testID).Scan(&isPending)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return isPending, err
+}
+
+// countMissingClientResults returns the number of subtests that have not
+// received a client capture yet.
+func countMissingClientResults(tx *sql.Tx, testID int) (int, error) {
+	var count int
+	err := tx.QueryRow(`
+	SELECT
+		count(*)
+	FROM subtests
+	LEFT JOIN client_captures
+	ON subtests.id = client_captures.subtest_id
+	WHERE
+		subtests.test_id = $1 AND
+		client_captures.id IS NULL
+	`, testID).Scan(&count)
+	return count, err
+}
+
+// FinalizeTest marks a test as complete and runs the finalizers.
+func FinalizeTest(tx *sql.Tx, testID int, finalizers []TestFinalizer) error {
+	_, err := tx.Exec(`
+	UPDATE tests
+	SET
+		is_pending = FALSE,
+		updated_at = now()
+	WHERE id = $1
+	`, testID)
+	if err != nil {
+		return err
+	}
+	return runTestFinalizers(tx, testID, finalizers)
+}
+
+// runTestFinalizers summarizes the results of a test. All finalizers derive
+// their results from the stored captures, such that they can run again when a
+// capture is added to a concluded test.
+func runTestFinalizers(tx *sql.Tx, testID int, finalizers []TestFinalizer) error {
+	for _, finalizer := range finalizers {
+		if err := finalizer(tx, testID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StoreServerCapture stores a server capture. Server captures are complete
+// once the connection is closed, which may be after the test was concluded.
+// In that case the results of the test are summarized again.
+func StoreServerCapture(db *sql.DB, capture *ServerCapture, finalizers []TestFinalizer) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err = addServerCapture(tx, capture, finalizers); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	tx = nil
+	return nil
+}
+
+// addServerCapture stores a server capture within a transaction, see
+// StoreServerCapture.
+func addServerCapture(tx *sql.Tx, capture *ServerCapture, finalizers []TestFinalizer) error {
+	var testID int
+	err := tx.QueryRow(`
+	SELECT test_id FROM subtests WHERE id = $1
+	`, capture.SubtestID).Scan(&testID)
+	if err != nil {
+		return err
+	}
+	// serialize with a concurrent conclusion of the test.
+	isPending, err := lockPendingTest(tx, testID)
+	if err != nil {
+		return err
+	}
+	if err = capture.Create(tx); err != nil {
+		return err
+	}
+	if isPending {
+		return nil
+	}
+	return runTestFinalizers(tx, testID, finalizers)
+}
+
+// updateMissingClientResults marks subtests that never received a client
+// result.
+func updateMissingClientResults(tx *sql.Tx, testID int) error {
+	_, err := tx.Exec(`
+	UPDATE subtests
+	SET
+		no_client_result = NOT EXISTS (
+			SELECT 1 FROM client_captures
+			WHERE subtest_id = subtests.id
+		)
+	WHERE test_id = $1
+	`, testID)
+	return err
+}
+
+// updateSubtestFailures marks subtests as failed if any capture failed.
+func updateSubtestFailures(tx *sql.Tx, testID int) error {
+	_, err := tx.Exec(`
+	UPDATE subtests
+	SET
+		has_failed = EXISTS (
+			SELECT 1 FROM client_captures
+			WHERE subtest_id = subtests.id AND has_failed
+		) OR EXISTS (
+			SELECT 1 FROM server_captures
+			WHERE subtest_id = subtests.id AND has_failed
+		)
+	WHERE test_id = $1
+	`, testID)
+	return err
+}
+
+// updateTestSummary derives HasFailed and IsMitm of a test from its subtests.
+func updateTestSummary(tx *sql.Tx, testID int) error {
+	_, err := tx.Exec(`
+	UPDATE tests
+	SET
+		has_failed = COALESCE((
+			SELECT bool_or(has_failed) FROM subtests
+			WHERE test_id = tests.id
+		), FALSE),
+		is_mitm = COALESCE((
+			SELECT bool_or(is_mitm) FROM subtests
+			WHERE test_id = tests.id
+		), FALSE)
+	WHERE id = $1
+	`, testID)
+	return err
+}
+
+// expirePendingTests concludes tests that are still pending after the period
+// in which changes are accepted. Returns the number of concluded tests.
+func expirePendingTests(db *sql.DB, mutableTestPeriodSecs int, finalizers []TestFinalizer) (int, error) {
+	rows, err := db.Query(`
+	SELECT id FROM tests
+	WHERE
+		is_pending AND
+		now() - created_at >= $1
+	`, mutableTestPeriodSecs)
+	if err != nil {
+		return 0, err
+	}
+	var testIDs []int
+	for rows.Next() {
+		var testID int
+		if err := rows.Scan(&testID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		testIDs = append(testIDs, testID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, testID := range testIDs {
+		concluded, err := expirePendingTest(db, testID, finalizers)
+		if err != nil {
+			return expired, err
+		}
+		if concluded {
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+// expirePendingTest concludes a single test unless it was concluded already.
+func expirePendingTest(db *sql.DB, testID int, finalizers []TestFinalizer) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+
+	isPending, err := lockPendingTest(tx, testID)
+	if err != nil || !isPending {
+		return false, err
+	}
+	if err = FinalizeTest(tx, testID, finalizers); err != nil {
+		return false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	tx = nil
+	return true, nil
+}
+
+// startPendingTestExpiry periodically concludes abandoned tests in the
+// background.
+func (r *reporter) startPendingTestExpiry() {
+	interval := time.Duration(r.config.PendingTestExpiryIntervalSecs) * time.Second
+	go runPeriodically(interval, func() {
+		n, err := expirePendingTests(r.db, r.config.MutableTestPeriodSecs, r.testFinalizers)
+		if err != nil {
+			log.Printf("Failed to expire pending tests: %s", err)
+		}
+		if n > 0 {
+			log.Printf("Concluded %d expired pending tests", n)
+		}
+	})
+}
@@ -0,0 +1,113 @@
+// Fingerprinting of TLS clients and servers.
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// isGrease returns true for the reserved GREASE values (RFC 8701) which are
+// randomly chosen by clients and must be ignored for fingerprints.
+func isGrease(value uint16) bool {
+	return value&0x0f0f == 0x0a0a && value>>8 == value&0xff
+}
+
+// joinValues formats values as decimal numbers separated by dashes, skipping
+// GREASE values.
+func joinValues(values []uint16) string {
+	var parts []string
+	for _, value := range values {
+		if !isGrease(value) {
+			parts = append(parts, strconv.Itoa(int(value)))
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// withoutGrease returns a copy of the values without GREASE values. The result
+// is never nil such that it is always encoded as a JSON array.
+func withoutGrease(values []uint16) []uint16 {
+	result := []uint16{}
+	for _, value := range values {
+		if !isGrease(value) {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// ja3String returns the JA3 description of a ClientHello or an empty string if
+// there is none:
+// SSLVersion,Ciphers,Extensions,EllipticCurves,EllipticCurvePointFormats
+func ja3String(hello *ClientHello) string {
+	if hello == nil {
+		return ""
+	}
+	pointFormats := make([]uint16, len(hello.ECPointFormats))
+	for i, format := range hello.ECPointFormats {
+		pointFormats[i] = uint16(format)
+	}
+	return strings.Join([]string{
+		strconv.Itoa(int(hello.Version)),
+		joinValues(hello.CipherSuites),
+		joinValues(hello.Extensions),
+		joinValues(hello.SupportedGroups),
+		joinValues(pointFormats),
+	}, ",")
+}
+
+// ja3Fingerprint returns the JA3 hash (hex-encoded MD5) of the ClientHello or an
+// empty string if there is none.
+func ja3Fingerprint(hello *ClientHello) string {
+	if hello == nil {
+		return ""
+	}
+	hash := md5.Sum([]byte(ja3String(hello)))
+	return hex.EncodeToString(hash[:])
+}
+
+// normalizeClientHello returns the fingerprint of a ClientHello or nil if there
+// is none.
+func normalizeClientHello(hello *ClientHello) *ClientHelloFingerprint {
+	if hello == nil {
+		return nil
+	}
+	ints := func(values []int) []int {
+		return append([]int{}, values...)
+	}
+	return &ClientHelloFingerprint{
+		Version:             hello.Version,
+		CipherSuites:        withoutGrease(hello.CipherSuites),
+		CompressionMethods:  ints(hello.CompressionMethods),
+		Extensions:          withoutGrease(hello.Extensions),
+		SupportedVersions:   withoutGrease(hello.SupportedVersions),
+		SupportedGroups:     withoutGrease(hello.SupportedGroups),
+		ECPointFormats:      ints(hello.ECPointFormats),
+		KeyShareGroups:      withoutGrease(hello.KeyShareGroups),
+		SignatureAlgorithms: withoutGrease(hello.SignatureAlgorithms),
+		ALPNProtocols:       append([]string{}, hello.ALPNProtocols...),
+		PSKModes:            ints(hello.PSKModes),
+	}
+}
+
+// ja3sString returns the JA3S description of a ServerHello:
+// SSLVersion,Cipher,Extensions
+func ja3sString(hello *ServerHello) string {
+	return strings.Join([]string{
+		strconv.Itoa(int(hello.Version)),
+		strconv.Itoa(int(hello.CipherSuite)),
+		joinValues(hello.Extensions),
+	}, ",")
+}
+
+// ja3sFingerprint returns the JA3S hash (hex-encoded MD5) of the ServerHello
+// or an empty string if there is none.
+func ja3sFingerprint(hello *ServerHello) string {
+	if hello == nil {
+		return ""
+	}
+	hash := md5.Sum([]byte(ja3sString(hello)))
+	return hex.EncodeToString(hash[:])
+}
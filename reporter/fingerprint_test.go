@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJA3Fingerprint(t *testing.T) {
+	hello := &ClientHello{
+		Version:         0x0303,
+		CipherSuites:    []uint16{0x1a1a, 0x1301, 0xc02f},
+		Extensions:      []uint16{0x2a2a, extensionServerName, extensionSupportedGroups, extensionECPointFormats},
+		SupportedGroups: []uint16{0x3a3a, 29, 23},
+		ECPointFormats:  []int{0},
+	}
+	expected := "771,4865-49199,0-10-11,29-23,0"
+	if s := ja3String(hello); s != expected {
+		t.Errorf("expected %q, got %q", expected, s)
+	}
+	// md5("771,4865-49199,0-10-11,29-23,0")
+	if fp := ja3Fingerprint(hello); fp != "bca193bf3b6d2156cfbe0e6b4b306d3e" {
+		t.Errorf("unexpected fingerprint: %q", fp)
+	}
+	if fp := ja3Fingerprint(nil); fp != "" {
+		t.Errorf("expected no fingerprint without ClientHello, got %q", fp)
+	}
+	if fp := ja3Fingerprint(&ClientHello{Version: 0x0301}); fp == "" {
+		t.Error("expected fingerprint for minimal ClientHello")
+	}
+}
+
+func TestNormalizeClientHello(t *testing.T) {
+	hello := &ClientHello{
+		Version:           0x0303,
+		Random:            make([]byte, 32),
+		SessionID:         []byte{1, 2, 3},
+		CipherSuites:      []uint16{0x1a1a, 0x1301},
+		Extensions:        []uint16{0x2a2a, extensionServerName, extensionSupportedVersions},
+		ServerName:        "example.com",
+		SupportedVersions: []uint16{0x4a4a, 0x7f16, 0x0303},
+		KeyShareGroups:    []uint16{0x5a5a, 29},
+		PaddingLength:     100,
+	}
+	expected := &ClientHelloFingerprint{
+		Version:             0x0303,
+		CipherSuites:        []uint16{0x1301},
+		CompressionMethods:  []int{},
+		Extensions:          []uint16{extensionServerName, extensionSupportedVersions},
+		SupportedVersions:   []uint16{0x7f16, 0x0303},
+		SupportedGroups:     []uint16{},
+		ECPointFormats:      []int{},
+		KeyShareGroups:      []uint16{29},
+		SignatureAlgorithms: []uint16{},
+		ALPNProtocols:       []string{},
+		PSKModes:            []int{},
+	}
+	if fingerprint := normalizeClientHello(hello); !reflect.DeepEqual(fingerprint, expected) {
+		t.Errorf("unexpected fingerprint: %+v", fingerprint)
+	}
+	if fingerprint := normalizeClientHello(nil); fingerprint != nil {
+		t.Errorf("expected no fingerprint without ClientHello, got %+v", fingerprint)
+	}
+	if s := ja3String(nil); s != "" {
+		t.Errorf("expected no JA3 string without ClientHello, got %q", s)
+	}
+}
+
+func TestJA3SFingerprint(t *testing.T) {
+	hello := &ServerHello{
+		Version:     0x0303,
+		CipherSuite: 0x1301,
+		Extensions:  []uint16{extensionSupportedVersions, extensionKeyShare},
+	}
+	expected := "771,4865,43-51"
+	if s := ja3sString(hello); s != expected {
+		t.Errorf("expected %q, got %q", expected, s)
+	}
+	if fp := ja3sFingerprint(nil); fp != "" {
+		t.Errorf("expected no fingerprint without ServerHello, got %q", fp)
+	}
+}
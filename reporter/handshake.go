@@ -0,0 +1,44 @@
+// Frame analysis: comparison of the handshakes seen by the client and server.
+package main
+
+import (
+	"bytes"
+)
+
+// handshakeView contains the messages as sent and received by one side.
+type handshakeView struct {
+	ClientHello []byte
+	ServerHello []byte
+}
+
+// clientHandshakeView returns the ClientHello sent and the ServerHello
+// received by the client.
+func clientHandshakeView(capture *ClientCapture) handshakeView {
+	return handshakeView{
+		ClientHello: findHandshakeMessage(extractHandshakeMessages(capture.Frames, false), typeClientHello),
+		ServerHello: findHandshakeMessage(extractHandshakeMessages(capture.Frames, true), typeServerHello),
+	}
+}
+
+// serverHandshakeView returns the ClientHello received and the ServerHello
+// sent by the server.
+func serverHandshakeView(capture *ServerCapture) handshakeView {
+	return handshakeView{
+		ClientHello: findHandshakeMessage(extractHandshakeMessages(capture.Frames, true), typeClientHello),
+		ServerHello: findHandshakeMessage(extractHandshakeMessages(capture.Frames, false), typeServerHello),
+	}
+}
+
+// matchServerCapture returns the server capture that received the ClientHello
+// as sent by the client. If there is no such capture, the last one is returned.
+func matchServerCapture(client handshakeView, serverCaptures []*ServerCapture) (*ServerCapture, handshakeView) {
+	var capture *ServerCapture
+	var view handshakeView
+	for _, capture = range serverCaptures {
+		view = serverHandshakeView(capture)
+		if client.ClientHello != nil && bytes.Equal(client.ClientHello, view.ClientHello) {
+			break
+		}
+	}
+	return capture, view
+}
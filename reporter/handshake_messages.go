@@ -0,0 +1 @@
+../handshake_messages.go
\ No newline at end of file
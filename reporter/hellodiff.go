@@ -0,0 +1,163 @@
+// Comparison of the ClientHello as sent by the client and as received by the
+// server.
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errNoClientResult = gin.H{"error": "subtest has no client result"}
+
+// helloField describes a ClientHello field that is compared.
+type helloField struct {
+	Name string
+	// whether the field is a list for which added and removed values are
+	// reported.
+	IsList bool
+	Value  func(hello *ClientHello) interface{}
+}
+
+var helloFields = []helloField{
+	{"version", false, func(h *ClientHello) interface{} { return h.Version }},
+	{"random", false, func(h *ClientHello) interface{} { return h.Random }},
+	{"session_id", false, func(h *ClientHello) interface{} { return h.SessionID }},
+	{"cipher_suites", true, func(h *ClientHello) interface{} { return h.CipherSuites }},
+	{"compression_methods", true, func(h *ClientHello) interface{} { return h.CompressionMethods }},
+	{"extensions", true, func(h *ClientHello) interface{} { return h.Extensions }},
+	{"server_name", false, func(h *ClientHello) interface{} { return h.ServerName }},
+	{"supported_versions", true, func(h *ClientHello) interface{} { return h.SupportedVersions }},
+	{"supported_groups", true, func(h *ClientHello) interface{} { return h.SupportedGroups }},
+	{"ec_point_formats", true, func(h *ClientHello) interface{} { return h.ECPointFormats }},
+	{"key_share_groups", true, func(h *ClientHello) interface{} { return h.KeyShareGroups }},
+	{"signature_algorithms", true, func(h *ClientHello) interface{} { return h.SignatureAlgorithms }},
+	{"alpn_protocols", true, func(h *ClientHello) interface{} { return h.ALPNProtocols }},
+	{"psk_modes", true, func(h *ClientHello) interface{} { return h.PSKModes }},
+	{"padding_length", false, func(h *ClientHello) interface{} { return h.PaddingLength }},
+}
+
+// helloFieldDiff describes a field that differs between the sent and received
+// ClientHello. For lists, the values that only appear in the received message
+// (Added) and those that only appear in the sent message (Removed) are given.
+// If both are empty, only the order has changed.
+type helloFieldDiff struct {
+	Field    string        `json:"field"`
+	Sent     interface{}   `json:"sent"`
+	Received interface{}   `json:"received"`
+	Added    []interface{} `json:"added,omitempty"`
+	Removed  []interface{} `json:"removed,omitempty"`
+}
+
+// diffClientHellos compares all fields of two ClientHello messages. If either
+// message is missing (or could not be parsed), a single "client_hello"
+// difference is returned.
+func diffClientHellos(sent, received *ClientHello) []helloFieldDiff {
+	diffs := []helloFieldDiff{}
+	if sent == nil || received == nil {
+		if sent != nil || received != nil {
+			diffs = append(diffs, helloFieldDiff{
+				Field:    "client_hello",
+				Sent:     sent,
+				Received: received,
+			})
+		}
+		return diffs
+	}
+	for _, field := range helloFields {
+		sentValue, receivedValue := field.Value(sent), field.Value(received)
+		if reflect.DeepEqual(sentValue, receivedValue) {
+			continue
+		}
+		diff := helloFieldDiff{
+			Field:    field.Name,
+			Sent:     sentValue,
+			Received: receivedValue,
+		}
+		if field.IsList {
+			diff.Added = listDifference(receivedValue, sentValue)
+			diff.Removed = listDifference(sentValue, receivedValue)
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs
+}
+
+// listDifference returns the elements of slice a that are not in slice b.
+func listDifference(a, b interface{}) []interface{} {
+	aValue, bValue := reflect.ValueOf(a), reflect.ValueOf(b)
+	var values []interface{}
+	for i := 0; i < aValue.Len(); i++ {
+		value := aValue.Index(i).Interface()
+		found := false
+		for j := 0; j < bValue.Len() && !found; j++ {
+			found = bValue.Index(j).Interface() == value
+		}
+		if !found {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// serverCaptureHelloDiff compares the ClientHello received by one server
+// capture with the ClientHello sent by the client.
+type serverCaptureHelloDiff struct {
+	BeginTime   time.Time    `json:"begin_time"`
+	ClientIP    net.IP       `json:"client_ip"`
+	ClientHello *ClientHello `json:"client_hello"`
+	// whether the messages are identical byte for byte.
+	IsIdentical bool             `json:"is_identical"`
+	Differences []helloFieldDiff `json:"differences"`
+}
+
+// diffSubtestClientHellos compares the ClientHello of the client capture with
+// those of every server capture.
+func diffSubtestClientHellos(result *SubtestResult) (*ClientHello, []serverCaptureHelloDiff) {
+	sentMessage := clientHandshakeView(result.ClientCapture).ClientHello
+	sent := parseClientHelloMessage(sentMessage)
+	diffs := []serverCaptureHelloDiff{}
+	for _, capture := range result.ServerCaptures {
+		receivedMessage := serverHandshakeView(capture).ClientHello
+		received := parseClientHelloMessage(receivedMessage)
+		diffs = append(diffs, serverCaptureHelloDiff{
+			BeginTime:   capture.BeginTime,
+			ClientIP:    capture.ClientIP,
+			ClientHello: received,
+			IsIdentical: sentMessage != nil && bytes.Equal(sentMessage, receivedMessage),
+			Differences: diffClientHellos(sent, received),
+		})
+	}
+	return sent, diffs
+}
+
+func (r *reporter) getClientHelloDiff(c *gin.Context) {
+	subtestNumber, ok := r.getSubtestNumber(c)
+	if !ok {
+		return
+	}
+	results, ok := r.getSubtestResults(c)
+	if !ok {
+		return
+	}
+	for _, result := range results {
+		if result.Number != subtestNumber {
+			continue
+		}
+		if result.ClientCapture == nil {
+			c.JSON(http.StatusNotFound, errNoClientResult)
+			return
+		}
+		sent, diffs := diffSubtestClientHellos(result)
+		c.JSON(http.StatusOK, gin.H{
+			"client_hello":    sent,
+			"server_captures": diffs,
+		})
+		return
+	}
+	c.JSON(http.StatusNotFound, errSubTestNotFound)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+func TestDiffClientHellos(t *testing.T) {
+	sent := &ClientHello{
+		Version:      0x0303,
+		Random:       []byte{1, 2, 3},
+		CipherSuites: []uint16{0x1301, 0xc02f, 0xc030},
+		Extensions:   []uint16{extensionServerName, extensionSupportedGroups, extensionALPN},
+		ServerName:   "example.com",
+	}
+	received := &ClientHello{
+		Version:      0x0303,
+		Random:       []byte{1, 2, 4},
+		CipherSuites: []uint16{0xc030, 0xc02f, 0x002f},
+		Extensions:   []uint16{extensionSupportedGroups, extensionServerName, extensionALPN},
+		ServerName:   "example.com",
+	}
+
+	if diffs := diffClientHellos(sent, sent); len(diffs) != 0 {
+		t.Errorf("expected no differences, got %+v", diffs)
+	}
+
+	expected := []helloFieldDiff{
+		{
+			Field:    "random",
+			Sent:     sent.Random,
+			Received: received.Random,
+		},
+		{
+			Field:    "cipher_suites",
+			Sent:     sent.CipherSuites,
+			Received: received.CipherSuites,
+			Added:    []interface{}{uint16(0x002f)},
+			Removed:  []interface{}{uint16(0x1301)},
+		},
+		// only the order changed
+		{
+			Field:    "extensions",
+			Sent:     sent.Extensions,
+			Received: received.Extensions,
+		},
+	}
+	if diffs := diffClientHellos(sent, received); !reflect.DeepEqual(diffs, expected) {
+		t.Errorf("unexpected differences:\n got: %+v\nwant: %+v", diffs, expected)
+	}
+
+	diffs := diffClientHellos(sent, nil)
+	if len(diffs) != 1 || diffs[0].Field != "client_hello" {
+		t.Errorf("expected client_hello difference, got %+v", diffs)
+	}
+	if diffs := diffClientHellos(nil, nil); len(diffs) != 0 {
+		t.Errorf("expected no differences, got %+v", diffs)
+	}
+}
+
+func TestDiffSubtestClientHellos(t *testing.T) {
+	message := buildClientHello(func(b *cryptobyte.Builder) {})
+	modified := buildClientHello(func(b *cryptobyte.Builder) {
+		addExtension(b, extensionPadding, func(b *cryptobyte.Builder) {
+			b.AddBytes(make([]byte, 3))
+		})
+	})
+	result := &SubtestResult{
+		ClientCapture: &ClientCapture{
+			Capture: Capture{Frames: []Frame{{IsRead: false, Data: handshakeRecord(typeClientHello, message[4:])}}},
+		},
+		ServerCaptures: []*ServerCapture{
+			{Capture: Capture{Frames: []Frame{{IsRead: true, Data: handshakeRecord(typeClientHello, message[4:])}}}},
+			{Capture: Capture{Frames: []Frame{{IsRead: true, Data: handshakeRecord(typeClientHello, modified[4:])}}}},
+		},
+	}
+	sent, diffs := diffSubtestClientHellos(result)
+	if sent == nil || len(diffs) != 2 {
+		t.Fatalf("unexpected result: %+v, %+v", sent, diffs)
+	}
+	if !diffs[0].IsIdentical || len(diffs[0].Differences) != 0 {
+		t.Errorf("expected identical ClientHello, got %+v", diffs[0])
+	}
+	if diffs[1].IsIdentical || len(diffs[1].Differences) != 2 ||
+		diffs[1].Differences[0].Field != "extensions" ||
+		diffs[1].Differences[1].Field != "padding_length" {
+		t.Errorf("unexpected differences: %+v", diffs[1].Differences)
+	}
+}
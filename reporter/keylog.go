@@ -0,0 +1,108 @@
+// Merging of NSS Key Log files from client and server captures.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// keyLogEntry is a single line from a NSS Key Log file.
+type keyLogEntry struct {
+	Label        string
+	ClientRandom string
+	Secret       string
+}
+
+// parseKeyLogLine parses a line of the form "<label> <client_random> <secret>".
+// Comments and malformed lines are rejected.
+func parseKeyLogLine(line string) (keyLogEntry, bool) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 || strings.HasPrefix(fields[0], "#") {
+		return keyLogEntry{}, false
+	}
+	return keyLogEntry{
+		Label:        fields[0],
+		ClientRandom: strings.ToLower(fields[1]),
+		Secret:       strings.ToLower(fields[2]),
+	}, true
+}
+
+// parseKeyLog returns all valid entries from the key log.
+func parseKeyLog(keyLog string) []keyLogEntry {
+	var entries []keyLogEntry
+	for _, line := range strings.Split(keyLog, "\n") {
+		if entry, ok := parseKeyLogLine(line); ok {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// keyLogMismatch describes a secret for which the client and server disagree.
+type keyLogMismatch struct {
+	SubtestNumber int
+	Label         string
+	ClientRandom  string
+}
+
+// mergeKeyLogs writes a key log containing the unique entries of all client
+// and server captures, grouped by subtest and side. Entries with the same label
+// and client random but a different secret in the client and server key logs
+// are flagged and returned, this happens when the connection is intercepted.
+func mergeKeyLogs(results []*SubtestResult) ([]byte, []keyLogMismatch) {
+	var buffer bytes.Buffer
+	var mismatches []keyLogMismatch
+	seen := make(map[keyLogEntry]bool)
+	writeGroup := func(comment string, entries []keyLogEntry) {
+		var unseen []keyLogEntry
+		for _, entry := range entries {
+			if !seen[entry] {
+				seen[entry] = true
+				unseen = append(unseen, entry)
+			}
+		}
+		// omit the header if all entries were written before.
+		if len(unseen) == 0 {
+			return
+		}
+		fmt.Fprintf(&buffer, "# %s\n", comment)
+		for _, entry := range unseen {
+			fmt.Fprintf(&buffer, "%s %s %s\n", entry.Label, entry.ClientRandom, entry.Secret)
+		}
+	}
+
+	for _, result := range results {
+		var clientEntries, serverEntries []keyLogEntry
+		if result.ClientCapture != nil {
+			clientEntries = parseKeyLog(result.ClientCapture.KeyLog)
+		}
+		for _, capture := range result.ServerCaptures {
+			serverEntries = append(serverEntries, parseKeyLog(capture.KeyLog)...)
+		}
+
+		writeGroup(fmt.Sprintf("Subtest %d, client", result.Number), clientEntries)
+		writeGroup(fmt.Sprintf("Subtest %d, server", result.Number), serverEntries)
+
+		clientSecrets := make(map[keyLogEntry]string)
+		for _, entry := range clientEntries {
+			key := keyLogEntry{Label: entry.Label, ClientRandom: entry.ClientRandom}
+			clientSecrets[key] = entry.Secret
+		}
+		for _, entry := range serverEntries {
+			key := keyLogEntry{Label: entry.Label, ClientRandom: entry.ClientRandom}
+			if secret, ok := clientSecrets[key]; ok && secret != entry.Secret {
+				fmt.Fprintf(&buffer, "# MISMATCH: subtest %d, client and server disagree on %s %s\n",
+					result.Number, entry.Label, entry.ClientRandom)
+				mismatches = append(mismatches, keyLogMismatch{
+					SubtestNumber: result.Number,
+					Label:         entry.Label,
+					ClientRandom:  entry.ClientRandom,
+				})
+				// report a conflict only once per key.
+				delete(clientSecrets, key)
+			}
+		}
+	}
+	return buffer.Bytes(), mismatches
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMergeKeyLogs(t *testing.T) {
+	results := []*SubtestResult{
+		{
+			Subtest: &Subtest{Number: 1},
+			ClientCapture: &ClientCapture{Capture: Capture{
+				KeyLog: "CLIENT_RANDOM AA01 BB01\n\nCLIENT_RANDOM aa02 bb02\n",
+			}},
+			ServerCaptures: []*ServerCapture{
+				{Capture: Capture{KeyLog: "CLIENT_RANDOM aa01 bb01\nCLIENT_RANDOM aa03 bb03\n"}},
+			},
+		},
+		{
+			Subtest: &Subtest{Number: 2},
+			ClientCapture: &ClientCapture{Capture: Capture{
+				KeyLog: "CLIENT_RANDOM aa04 bb04\n",
+			}},
+			ServerCaptures: []*ServerCapture{
+				{Capture: Capture{KeyLog: "CLIENT_RANDOM aa04 cc04\n"}},
+			},
+		},
+		{
+			Subtest: &Subtest{Number: 3},
+			ClientCapture: &ClientCapture{Capture: Capture{
+				KeyLog: "CLIENT_RANDOM aa05 bb05\n",
+			}},
+			ServerCaptures: []*ServerCapture{
+				{Capture: Capture{KeyLog: "CLIENT_RANDOM aa05 bb05\n"}},
+			},
+		},
+	}
+	expected := `# Subtest 1, client
+CLIENT_RANDOM aa01 bb01
+CLIENT_RANDOM aa02 bb02
+# Subtest 1, server
+CLIENT_RANDOM aa03 bb03
+# Subtest 2, client
+CLIENT_RANDOM aa04 bb04
+# Subtest 2, server
+CLIENT_RANDOM aa04 cc04
+# MISMATCH: subtest 2, client and server disagree on CLIENT_RANDOM aa04
+# Subtest 3, client
+CLIENT_RANDOM aa05 bb05
+`
+	keyLog, mismatches := mergeKeyLogs(results)
+	if string(keyLog) != expected {
+		t.Errorf("unexpected key log:\n%s", keyLog)
+	}
+	if len(mismatches) != 1 || mismatches[0].SubtestNumber != 2 {
+		t.Errorf("unexpected mismatches: %v", mismatches)
+	}
+}
@@ -90,7 +90,11 @@ func (ln *listener) handleConnection(c net.Conn) {
 		log.Printf("%s / %s - failed to read a record: %v\n", remoteAddr, localAddr, err)
 		return
 	}
-	sni, isTLS := parseClientHello(buffer)
+	clientHello, isTLS := parseClientHello(buffer)
+	var sni string
+	if clientHello != nil {
+		sni = clientHello.ServerName
+	}
 	log.Printf("%s / %s - SNI: %v (isTLS: %t)\n", remoteAddr, localAddr, sni, isTLS)
 
 	// Disable timeout again, this is the responsibility of the (upstream)
@@ -111,8 +115,12 @@ func (ln *listener) handleConnection(c net.Conn) {
 					Frames:    []Frame{},
 					HasFailed: true,
 				},
-				ClientIP: net.ParseIP(parseHost(remoteAddr)),
-				ServerIP: net.ParseIP(parseHost(localAddr)),
+				ClientIP:       net.ParseIP(parseHost(remoteAddr)),
+				ServerIP:       net.ParseIP(parseHost(localAddr)),
+				ClientHello:    clientHello,
+				JA3String:      ja3String(clientHello),
+				JA3Fingerprint: ja3Fingerprint(clientHello),
+				Fingerprint:    normalizeClientHello(clientHello),
 			}
 			capturedConn := &serverCaptureConn{
 				CaptureConn:        NewCaptureConn(peekableConn, &serverCapture.Frames),
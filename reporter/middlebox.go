@@ -0,0 +1,113 @@
+// Attribution of intercepted connections to known middlebox products.
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// middleboxFingerprint identifies an interception product by the ClientHello
+// that it sends to the server (JA3) and/or the ServerHello that it sends to the
+// client (JA3S). Empty fingerprints are ignored.
+type middleboxFingerprint struct {
+	Vendor string `json:"vendor"`
+	JA3    string `json:"ja3"`
+	JA3S   string `json:"ja3s"`
+}
+
+// middleboxDatabase contains the known fingerprints in order of preference.
+type middleboxDatabase struct {
+	fingerprints []middleboxFingerprint
+}
+
+// loadMiddleboxDatabase reads a JSON array of fingerprints from a file.
+func loadMiddleboxDatabase(filename string) (*middleboxDatabase, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var fingerprints []middleboxFingerprint
+	if err = json.NewDecoder(file).Decode(&fingerprints); err != nil {
+		return nil, err
+	}
+	for i, fingerprint := range fingerprints {
+		if fingerprint.Vendor == "" {
+			return nil, fmt.Errorf("fingerprint %d has no vendor", i+1)
+		}
+		if fingerprint.JA3 == "" && fingerprint.JA3S == "" {
+			return nil, fmt.Errorf("fingerprint %d (%s) has no JA3 or JA3S", i+1, fingerprint.Vendor)
+		}
+	}
+	return &middleboxDatabase{fingerprints}, nil
+}
+
+// Lookup returns the vendor of the first fingerprint that matches any of the
+// given JA3 (client) or JA3S (server) fingerprints, or an empty string if there
+// is none.
+func (db *middleboxDatabase) Lookup(clientFingerprints, serverFingerprints []string) string {
+	contains := func(values []string, value string) bool {
+		for _, v := range values {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	}
+	for _, fingerprint := range db.fingerprints {
+		if (fingerprint.JA3 != "" && contains(clientFingerprints, fingerprint.JA3)) ||
+			(fingerprint.JA3S != "" && contains(serverFingerprints, fingerprint.JA3S)) {
+			return fingerprint.Vendor
+		}
+	}
+	return ""
+}
+
+// Identify returns the vendor of the middlebox that intercepted a subtest,
+// based on the ClientHellos that reached the server and the ServerHello that
+// reached the client. Only subtests with a MITM verdict are attributed, the
+// verdict must be computed before.
+func (db *middleboxDatabase) Identify(result *SubtestResult) string {
+	if !result.IsMitm {
+		return ""
+	}
+	var clientFingerprints, serverFingerprints []string
+	for _, capture := range result.ServerCaptures {
+		if capture.JA3Fingerprint != "" {
+			clientFingerprints = append(clientFingerprints, capture.JA3Fingerprint)
+		}
+	}
+	if result.ClientCapture != nil {
+		serverHello := parseServerHelloMessage(clientHandshakeView(result.ClientCapture).ServerHello)
+		if fingerprint := ja3sFingerprint(serverHello); fingerprint != "" {
+			serverFingerprints = append(serverFingerprints, fingerprint)
+		}
+	}
+	return db.Lookup(clientFingerprints, serverFingerprints)
+}
+
+// makeMiddleboxFinalizer returns a finalizer that records the vendor of known
+// middleboxes for every intercepted subtest. It must run after the verdicts
+// have been updated.
+func makeMiddleboxFinalizer(db *middleboxDatabase) TestFinalizer {
+	return func(tx *sql.Tx, testID int) error {
+		results, err := QuerySubtestResults(tx, testID)
+		if err != nil {
+			return err
+		}
+		for _, result := range results {
+			_, err = tx.Exec(`
+			UPDATE subtests
+			SET
+				middlebox_vendor = $2
+			WHERE id = $1
+			`, result.ID, db.Identify(result))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestLoadMiddleboxDatabase(t *testing.T) {
+	for _, test := range []struct {
+		contents string
+		valid    bool
+	}{
+		{`[{"vendor": "Foo", "ja3": "a"}, {"vendor": "Bar", "ja3s": "b"}]`, true},
+		{`[]`, true},
+		{`[{"vendor": "Foo"}]`, false},
+		{`[{"ja3": "a"}]`, false},
+		{`{}`, false},
+	} {
+		file, err := ioutil.TempFile("", "middleboxes")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(file.Name())
+		file.WriteString(test.contents)
+		file.Close()
+
+		db, err := loadMiddleboxDatabase(file.Name())
+		if test.valid && (err != nil || db == nil) {
+			t.Errorf("%s: unexpected error: %v", test.contents, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("%s: expected error", test.contents)
+		}
+	}
+}
+
+func TestMiddleboxLookup(t *testing.T) {
+	db := &middleboxDatabase{[]middleboxFingerprint{
+		{Vendor: "Foo", JA3: "aaaa"},
+		{Vendor: "Bar", JA3S: "bbbb"},
+		{Vendor: "Baz", JA3: "cccc", JA3S: "bbbb"},
+	}}
+	for _, test := range []struct {
+		client, server []string
+		vendor         string
+	}{
+		{nil, nil, ""},
+		{[]string{"aaaa"}, nil, "Foo"},
+		{[]string{"xxxx", "aaaa"}, []string{"bbbb"}, "Foo"},
+		{[]string{"cccc"}, nil, "Baz"},
+		{[]string{"cccc"}, []string{"bbbb"}, "Bar"},
+		// a server fingerprint does not match a client fingerprint.
+		{[]string{"bbbb"}, []string{"aaaa"}, ""},
+	} {
+		if vendor := db.Lookup(test.client, test.server); vendor != test.vendor {
+			t.Errorf("%v/%v: expected %q, got %q", test.client, test.server, test.vendor, vendor)
+		}
+	}
+}
+
+func TestMiddleboxIdentify(t *testing.T) {
+	hello := &ClientHello{Version: 0x0303, CipherSuites: []uint16{0xc02f}}
+	serverHello := handshakeRecord(typeServerHello, serverHelloBody(1))
+	ja3s := ja3sFingerprint(parseServerHelloMessage(serverHello[5:]))
+	db := &middleboxDatabase{[]middleboxFingerprint{
+		{Vendor: "Foo", JA3: ja3Fingerprint(hello)},
+		{Vendor: "Bar", JA3S: ja3s},
+	}}
+
+	result := &SubtestResult{
+		Subtest: &Subtest{IsMitm: true},
+		ServerCaptures: []*ServerCapture{
+			{JA3Fingerprint: "other"},
+			{JA3Fingerprint: ja3Fingerprint(hello)},
+		},
+	}
+	if vendor := db.Identify(result); vendor != "Foo" {
+		t.Errorf("expected Foo, got %q", vendor)
+	}
+	// only intercepted subtests are attributed.
+	result.IsMitm = false
+	if vendor := db.Identify(result); vendor != "" {
+		t.Errorf("expected no vendor without MITM, got %q", vendor)
+	}
+	result = &SubtestResult{
+		Subtest: &Subtest{IsMitm: true},
+		ClientCapture: &ClientCapture{
+			Capture: Capture{Frames: []Frame{{IsRead: true, Data: serverHello}}},
+		},
+	}
+	if vendor := db.Identify(result); vendor != "Bar" {
+		t.Errorf("expected Bar, got %q", vendor)
+	}
+}
@@ -20,6 +20,9 @@ type Test struct {
 	HasFailed     bool      `json:"has_failed"`
 	IsMitm        bool      `json:"is_mitm"`
 	IsPending     bool      `json:"is_pending"`
+	// SHA256 hash of the token that permits the participant to remove the
+	// test.
+	DeletionTokenHash string `json:"-"`
 }
 
 // Specification of a subtest
@@ -27,6 +30,11 @@ type SubtestSpec struct {
 	Number        int    `json:"number"`
 	MaxTLSVersion uint16 `json:"max_tls_version"`
 	IsIPv6        bool   `json:"is_ipv6"`
+	// Exact version that should be negotiated, for example a TLS 1.3
+	// draft codepoint. If set to a TLS 1.3 version, TLS 1.3 is only
+	// negotiated if the client offers it. Zero means MaxTLSVersion (or
+	// the TLS 1.3 draft that is implemented by the client and server).
+	ExpectedVersion uint16 `json:"expected_version"`
 }
 
 // Actual instantiation of a subtest.
@@ -38,6 +46,17 @@ type Subtest struct {
 	IsIPv6        bool   `json:"is_ipv6"`
 	HasFailed     bool   `json:"has_failed"`
 	IsMitm        bool   `json:"is_mitm"`
+	MitmReason    string `json:"mitm_reason"`
+	// Version that should be negotiated (see SubtestSpec).
+	ExpectedVersion uint16 `json:"expected_version"`
+	// Set when the test was concluded without a client result.
+	NoClientResult bool `json:"no_client_result"`
+	// Number of server captures besides the one that matches the client.
+	ExtraConnections int `json:"extra_connections"`
+	// Known interception product that was involved (if any).
+	MiddleboxVendor string `json:"middlebox_vendor"`
+	// Outcome of the TLS 1.3 downgrade protection (see downgradeVerdict*).
+	DowngradeVerdict string `json:"downgrade_verdict"`
 }
 
 type Frame struct {
@@ -56,14 +75,100 @@ type Capture struct {
 	Frames           []Frame   `json:"frames"`
 	KeyLog           string    `json:"key_log"`
 	HasFailed        bool      `json:"has_failed"`
+	// Negotiated parameters (zero if the handshake failed).
+	CipherSuite       uint16 `json:"cipher_suite"`
+	KeyExchangeGroup  uint16 `json:"key_exchange_group"`
+	ALPNProtocol      string `json:"alpn_protocol"`
+	IsResumed         bool   `json:"is_resumed"`
+	HelloRetryRequest bool   `json:"hello_retry_request"`
 }
 
 type ServerCapture struct {
 	Capture
 	ClientIP net.IP `json:"client_ip"`
 	ServerIP net.IP `json:"server_ip"`
+	// Whether TLS 1.3 was disabled because the ClientHello did not offer
+	// the version that is expected for the subtest.
+	TLS13Disabled bool `json:"tls13_disabled"`
+	// ClientHello as received by the server (nil if it could not be
+	// parsed), its JA3 description and hash and the normalized form.
+	ClientHello    *ClientHello            `json:"client_hello"`
+	JA3String      string                  `json:"ja3_string"`
+	JA3Fingerprint string                  `json:"ja3_fingerprint"`
+	Fingerprint    *ClientHelloFingerprint `json:"fingerprint"`
 }
 
 type ClientCapture struct {
 	Capture
+	// Certificate chain (DER) as received by the client and the
+	// base64-encoded SHA-256 hashes of their SubjectPublicKeyInfo.
+	PeerCertificates [][]byte `json:"peer_certificates"`
+	SPKIHashes       []string `json:"spki_hashes"`
+	// Whether the leaf certificate differs from the one that was served.
+	CertSubstituted bool `json:"cert_substituted"`
+	// Result of the SPKI pin check by the client (see pinStatus*).
+	PinStatus string `json:"pin_status"`
+	// Whether the client received a ServerHello with a downgrade sentinel.
+	DowngradeSentinel bool `json:"downgrade_sentinel"`
+}
+
+// Result of the SPKI pin check by the client. It is empty if no certificate was
+// received or if no pins were available.
+const (
+	pinStatusMatch    = "match"
+	pinStatusMismatch = "mismatch"
+)
+
+// ClientHello contains the fields of a ClientHello message.
+type ClientHello struct {
+	// legacy_version
+	Version            uint16   `json:"version"`
+	Random             []byte   `json:"random"`
+	SessionID          []byte   `json:"session_id"`
+	CipherSuites       []uint16 `json:"cipher_suites"`
+	CompressionMethods []int    `json:"compression_methods"`
+	// Extension types in the order in which they appear.
+	Extensions []uint16 `json:"extensions"`
+
+	// Decoded extensions (empty if not present).
+	ServerName          string   `json:"server_name"`
+	SupportedVersions   []uint16 `json:"supported_versions"`
+	SupportedGroups     []uint16 `json:"supported_groups"`
+	ECPointFormats      []int    `json:"ec_point_formats"`
+	KeyShareGroups      []uint16 `json:"key_share_groups"`
+	SignatureAlgorithms []uint16 `json:"signature_algorithms"`
+	ALPNProtocols       []string `json:"alpn_protocols"`
+	PSKModes            []int    `json:"psk_modes"`
+	PaddingLength       int      `json:"padding_length"`
+}
+
+// ClientHelloFingerprint contains the fields of a ClientHello that identify the
+// TLS stack. GREASE values are removed and values that differ per connection
+// (random, session ID, server name and padding) are omitted.
+type ClientHelloFingerprint struct {
+	Version             uint16   `json:"version"`
+	CipherSuites        []uint16 `json:"cipher_suites"`
+	CompressionMethods  []int    `json:"compression_methods"`
+	Extensions          []uint16 `json:"extensions"`
+	SupportedVersions   []uint16 `json:"supported_versions"`
+	SupportedGroups     []uint16 `json:"supported_groups"`
+	ECPointFormats      []int    `json:"ec_point_formats"`
+	KeyShareGroups      []uint16 `json:"key_share_groups"`
+	SignatureAlgorithms []uint16 `json:"signature_algorithms"`
+	ALPNProtocols       []string `json:"alpn_protocols"`
+	PSKModes            []int    `json:"psk_modes"`
+}
+
+// Record of a request to a privileged API endpoint.
+type AuditLog struct {
+	ID         int       `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+	ApiKeyName string    `json:"api_key_name"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	// External TestID if the request concerns a test. This is not a
+	// reference such that the record outlives the test.
+	TestID   string `json:"test_id"`
+	ClientIP net.IP `json:"client_ip"`
+	Status   int    `json:"status"`
 }
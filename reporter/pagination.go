@@ -0,0 +1,146 @@
+// Filtering and cursor-based pagination for listing tests.
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// testListQuery builds the WHERE, ORDER BY and LIMIT clauses for QueryTests.
+type testListQuery struct {
+	conditions []string
+	args       []interface{}
+	ascending  bool
+	limit      int
+}
+
+// where adds a condition, the "$%d" verb is replaced by the placeholder for
+// the given value.
+func (q *testListQuery) where(condition string, values ...interface{}) {
+	placeholders := make([]interface{}, len(values))
+	for i, value := range values {
+		q.args = append(q.args, value)
+		placeholders[i] = len(q.args)
+	}
+	q.conditions = append(q.conditions, fmt.Sprintf(condition, placeholders...))
+}
+
+// SQL returns the query suffix and arguments. One more row than the limit is
+// requested to learn whether there is a next page.
+func (q *testListQuery) SQL() (string, []interface{}) {
+	var query string
+	if len(q.conditions) > 0 {
+		query = "WHERE " + strings.Join(q.conditions, " AND ") + "\n"
+	}
+	direction := "DESC"
+	if q.ascending {
+		direction = "ASC"
+	}
+	args := append(q.args[:len(q.args):len(q.args)], q.limit+1)
+	query += fmt.Sprintf("ORDER BY created_at %s, test_id %s\nLIMIT $%d",
+		direction, direction, len(args))
+	return query, args
+}
+
+// encodeCursor returns an opaque cursor that continues after the given test.
+func encodeCursor(test *Test) string {
+	value := test.CreatedAt.Format(time.RFC3339Nano) + "," + test.TestID
+	return base64.RawURLEncoding.EncodeToString([]byte(value))
+}
+
+func decodeCursor(cursor string) (time.Time, string, error) {
+	value, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", errors.New("invalid cursor")
+	}
+	parts := strings.SplitN(string(value), ",", 2)
+	if len(parts) != 2 || !ValidateUUID(parts[1]) {
+		return time.Time{}, "", errors.New("invalid cursor")
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, "", errors.New("invalid cursor")
+	}
+	return createdAt, parts[1], nil
+}
+
+// parseTestListQuery builds a query from the request parameters.
+func parseTestListQuery(params url.Values) (*testListQuery, error) {
+	q := &testListQuery{limit: defaultListLimit}
+
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxListLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+		q.limit = limit
+	}
+
+	switch params.Get("order") {
+	case "", "desc":
+	case "asc":
+		q.ascending = true
+	default:
+		return nil, errors.New("order must be asc or desc")
+	}
+
+	for _, column := range []string{"is_mitm", "has_failed", "is_pending"} {
+		value := params.Get(column)
+		if value == "" {
+			continue
+		}
+		flag, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a boolean", column)
+		}
+		q.where(column+" = $%d", flag)
+	}
+
+	if value := params.Get("client_version"); value != "" {
+		q.where("client_version = $%d", value)
+	}
+
+	if value := params.Get("user_agent"); value != "" {
+		q.where("strpos(lower(user_agent), lower($%d)) > 0", value)
+	}
+
+	for _, param := range []string{"created_after", "created_before"} {
+		value := params.Get(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a RFC 3339 time", param)
+		}
+		if param == "created_after" {
+			q.where("created_at >= $%d", t.UTC())
+		} else {
+			q.where("created_at < $%d", t.UTC())
+		}
+	}
+
+	if cursor := params.Get("cursor"); cursor != "" {
+		createdAt, testID, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		if q.ascending {
+			q.where("(created_at, test_id) > ($%d, $%d)", createdAt, testID)
+		} else {
+			q.where("(created_at, test_id) < ($%d, $%d)", createdAt, testID)
+		}
+	}
+
+	return q, nil
+}
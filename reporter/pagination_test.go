@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseTestListQuery(t *testing.T) {
+	test := &Test{
+		TestID:    "6b5742d9-722b-4d12-848a-c42da771b806",
+		CreatedAt: parseTime("2017-10-09T19:37:20Z"),
+	}
+	params := url.Values{
+		"limit":      {"10"},
+		"is_mitm":    {"true"},
+		"user_agent": {"Firefox"},
+		"cursor":     {encodeCursor(test)},
+	}
+	q, err := parseTestListQuery(params)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	query, args := q.SQL()
+	expectedQuery := "WHERE is_mitm = $1 AND strpos(lower(user_agent), lower($2)) > 0 AND (created_at, test_id) < ($3, $4)\n" +
+		"ORDER BY created_at DESC, test_id DESC\nLIMIT $5"
+	if query != expectedQuery {
+		t.Errorf("unexpected query: %s", query)
+	}
+	expectedArgs := []interface{}{true, "Firefox", test.CreatedAt, test.TestID, 11}
+	if !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("unexpected args: %v", args)
+	}
+}
+
+func TestParseTestListQueryInvalid(t *testing.T) {
+	for _, params := range []url.Values{
+		{"limit": {"0"}},
+		{"limit": {"1001"}},
+		{"order": {"random"}},
+		{"has_failed": {"maybe"}},
+		{"created_after": {"yesterday"}},
+		{"cursor": {"bm90LWEtY3Vyc29y"}},
+	} {
+		if _, err := parseTestListQuery(params); err == nil {
+			t.Errorf("expected error for %v", params)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+// Synthetic packet captures. Only TCP payloads are recorded, so the IP and TCP
+// headers are generated to allow analysis with tools like Wireshark.
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sort"
+	"time"
+)
+
+const (
+	// LINKTYPE_RAW, packets begin with an IPv4 or IPv6 header.
+	pcapLinkTypeRaw = 101
+	pcapSnapLen     = 65535
+
+	// Port of the server in synthetic captures.
+	syntheticServerPort = 443
+	// Ports of clients are derived from the subtest number and connection
+	// index and wrap within the ephemeral port range.
+	syntheticClientPortBase  = 49152
+	syntheticClientPortCount = 65536 - syntheticClientPortBase
+	// Subtest numbers are assumed to be smaller than this.
+	syntheticClientPortStride = 100
+	// Maximum TCP payload size, leaves space for the largest (IPv6) header.
+	syntheticMaxSegmentSize = pcapSnapLen - 40 - 20
+)
+
+// TCP header flags
+const (
+	tcpFlagFIN uint8 = 0x01
+	tcpFlagSYN uint8 = 0x02
+	tcpFlagPSH uint8 = 0x08
+	tcpFlagACK uint8 = 0x10
+)
+
+var (
+	dummyIPv4 = net.ParseIP("127.0.0.1")
+	dummyIPv6 = net.ParseIP("::1")
+)
+
+// pcapPacket is a single synthetic IP packet.
+type pcapPacket struct {
+	Time time.Time
+	Data []byte
+}
+
+// tcpConnection tracks the state of a synthetic TCP connection.
+type tcpConnection struct {
+	clientIP   net.IP
+	serverIP   net.IP
+	clientPort uint16
+	serverPort uint16
+	clientSeq  uint32
+	serverSeq  uint32
+	packets    []pcapPacket
+}
+
+func newTCPConnection(clientIP, serverIP net.IP, clientPort uint16) *tcpConnection {
+	// IPv4 headers can only be used if both ends are IPv4.
+	if clientIP.To4() != nil && serverIP.To4() != nil {
+		clientIP, serverIP = clientIP.To4(), serverIP.To4()
+	} else {
+		clientIP, serverIP = clientIP.To16(), serverIP.To16()
+	}
+	return &tcpConnection{
+		clientIP:   clientIP,
+		serverIP:   serverIP,
+		clientPort: clientPort,
+		serverPort: syntheticServerPort,
+	}
+}
+
+// addSegment appends a TCP segment in the given direction and advances the
+// sequence number of the sender.
+func (tc *tcpConnection) addSegment(t time.Time, fromClient bool, flags uint8, payload []byte) {
+	srcIP, dstIP := tc.clientIP, tc.serverIP
+	srcPort, dstPort := tc.clientPort, tc.serverPort
+	seq, ack := &tc.clientSeq, tc.serverSeq
+	if !fromClient {
+		srcIP, dstIP = dstIP, srcIP
+		srcPort, dstPort = dstPort, srcPort
+		seq, ack = &tc.serverSeq, tc.clientSeq
+	}
+	if flags&tcpFlagACK == 0 {
+		ack = 0
+	}
+
+	segment := make([]byte, 20+len(payload))
+	binary.BigEndian.PutUint16(segment[0:], srcPort)
+	binary.BigEndian.PutUint16(segment[2:], dstPort)
+	binary.BigEndian.PutUint32(segment[4:], *seq)
+	binary.BigEndian.PutUint32(segment[8:], ack)
+	segment[12] = 5 << 4 // data offset (in 32-bit words)
+	segment[13] = flags
+	binary.BigEndian.PutUint16(segment[14:], 65535) // window
+	copy(segment[20:], payload)
+	binary.BigEndian.PutUint16(segment[16:], tcpChecksum(srcIP, dstIP, segment))
+
+	*seq += uint32(len(payload))
+	if flags&(tcpFlagSYN|tcpFlagFIN) != 0 {
+		*seq++
+	}
+
+	tc.packets = append(tc.packets, pcapPacket{
+		Time: t,
+		Data: append(ipHeader(srcIP, dstIP, len(segment)), segment...),
+	})
+}
+
+// ipHeader returns an IPv4 or IPv6 header for a TCP segment.
+func ipHeader(srcIP, dstIP net.IP, payloadLength int) []byte {
+	if len(srcIP) == net.IPv4len {
+		header := make([]byte, 20)
+		header[0] = 0x45 // version 4, header length 5
+		binary.BigEndian.PutUint16(header[2:], uint16(len(header)+payloadLength))
+		binary.BigEndian.PutUint16(header[6:], 0x4000) // Don't Fragment
+		header[8] = 64                                 // TTL
+		header[9] = 6                                  // TCP
+		copy(header[12:], srcIP)
+		copy(header[16:], dstIP)
+		binary.BigEndian.PutUint16(header[10:], internetChecksum(0, header))
+		return header
+	}
+	header := make([]byte, 40)
+	header[0] = 0x60 // version 6
+	binary.BigEndian.PutUint16(header[4:], uint16(payloadLength))
+	header[6] = 6  // TCP
+	header[7] = 64 // hop limit
+	copy(header[8:], srcIP)
+	copy(header[24:], dstIP)
+	return header
+}
+
+// internetChecksum computes the one's complement checksum (RFC 1071), starting
+// from an initial (unfolded) sum.
+func internetChecksum(sum uint32, data []byte) uint16 {
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
+
+// tcpChecksum calculates the TCP checksum over a segment (with a zero checksum
+// field) and the IPv4 or IPv6 pseudo-header.
+func tcpChecksum(srcIP, dstIP net.IP, segment []byte) uint16 {
+	var sum uint32
+	for _, ip := range []net.IP{srcIP, dstIP} {
+		for i := 0; i < len(ip); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(ip[i:]))
+		}
+	}
+	length := uint32(len(segment))
+	sum += length>>16 + length&0xffff
+	sum += 6 // TCP
+	return internetChecksum(sum, segment)
+}
+
+// synthesizeConnection builds a TCP connection with a handshake, the recorded
+// frames and a connection teardown. If serverView is true, the frames were
+// recorded by the server, otherwise they were recorded by the client.
+func synthesizeConnection(clientIP, serverIP net.IP, clientPort uint16, capture *Capture, serverView bool) []pcapPacket {
+	tc := newTCPConnection(clientIP, serverIP, clientPort)
+
+	beginTime, endTime := capture.BeginTime, capture.EndTime
+	if n := len(capture.Frames); n > 0 {
+		if beginTime.IsZero() || capture.Frames[0].Time.Before(beginTime) {
+			beginTime = capture.Frames[0].Time
+		}
+		if endTime.Before(capture.Frames[n-1].Time) {
+			endTime = capture.Frames[n-1].Time
+		}
+	} else if endTime.Before(beginTime) {
+		endTime = beginTime
+	}
+
+	tc.addSegment(beginTime, true, tcpFlagSYN, nil)
+	tc.addSegment(beginTime, false, tcpFlagSYN|tcpFlagACK, nil)
+	tc.addSegment(beginTime, true, tcpFlagACK, nil)
+	for _, frame := range capture.Frames {
+		// the server reads what the client has written.
+		fromClient := frame.IsRead == serverView
+		data := frame.Data
+		for len(data) > 0 {
+			n := len(data)
+			if n > syntheticMaxSegmentSize {
+				n = syntheticMaxSegmentSize
+			}
+			tc.addSegment(frame.Time, fromClient, tcpFlagPSH|tcpFlagACK, data[:n])
+			data = data[n:]
+		}
+	}
+	tc.addSegment(endTime, true, tcpFlagFIN|tcpFlagACK, nil)
+	tc.addSegment(endTime, false, tcpFlagFIN|tcpFlagACK, nil)
+	tc.addSegment(endTime, true, tcpFlagACK, nil)
+	return tc.packets
+}
+
+// syntheticClientPort returns a client port for a subtest connection. Ports are
+// unique unless there are more connections than fit in the port range. The
+// client and server views use separate halves of the range, such that they
+// form distinct TCP streams when combined in one capture file.
+func syntheticClientPort(subtestNumber, connectionIndex int, serverView bool) uint16 {
+	const viewPortCount = syntheticClientPortCount / 2
+	offset := (syntheticClientPortStride*connectionIndex + subtestNumber) % viewPortCount
+	if serverView {
+		offset += viewPortCount
+	}
+	return uint16(syntheticClientPortBase + offset)
+}
+
+// dummyIP returns an address for when no server capture is available.
+func dummyIP(isIPv6 bool) net.IP {
+	if isIPv6 {
+		return dummyIPv6
+	}
+	return dummyIPv4
+}
+
+// clientConnectionPackets creates the client view of a subtest. Addresses are
+// taken from the last server capture.
+func clientConnectionPackets(result *SubtestResult) []pcapPacket {
+	if result.ClientCapture == nil {
+		return nil
+	}
+	clientIP, serverIP := dummyIP(result.IsIPv6), dummyIP(result.IsIPv6)
+	if n := len(result.ServerCaptures); n > 0 {
+		last := result.ServerCaptures[n-1]
+		clientIP, serverIP = last.ClientIP, last.ServerIP
+	}
+	clientPort := syntheticClientPort(result.Number, 0, false)
+	return synthesizeConnection(clientIP, serverIP, clientPort, &result.ClientCapture.Capture, false)
+}
+
+// serverConnectionPackets creates the server view of a subtest.
+func serverConnectionPackets(result *SubtestResult) []pcapPacket {
+	var packets []pcapPacket
+	for i, capture := range result.ServerCaptures {
+		clientPort := syntheticClientPort(result.Number, i, true)
+		packets = append(packets, synthesizeConnection(capture.ClientIP, capture.ServerIP, clientPort, &capture.Capture, true)...)
+	}
+	return packets
+}
+
+// buildClientPackets creates the client view of all subtests.
+func buildClientPackets(results []*SubtestResult) []pcapPacket {
+	var packets []pcapPacket
+	for _, result := range results {
+		packets = append(packets, clientConnectionPackets(result)...)
+	}
+	sortPackets(packets)
+	return packets
+}
+
+// buildServerPackets creates the server view of all subtests.
+func buildServerPackets(results []*SubtestResult) []pcapPacket {
+	var packets []pcapPacket
+	for _, result := range results {
+		packets = append(packets, serverConnectionPackets(result)...)
+	}
+	sortPackets(packets)
+	return packets
+}
+
+// sortPackets orders packets by time. Subtests run concurrently, so their
+// connections are interleaved.
+func sortPackets(packets []pcapPacket) {
+	sort.SliceStable(packets, func(i, j int) bool {
+		return packets[i].Time.Before(packets[j].Time)
+	})
+}
+
+// writePcap writes packets in the libpcap file format.
+func writePcap(w io.Writer, packets []pcapPacket) error {
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4) // microsecond resolution
+	binary.LittleEndian.PutUint16(header[4:], 2)          // major version
+	binary.LittleEndian.PutUint16(header[6:], 4)          // minor version
+	binary.LittleEndian.PutUint32(header[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(header[20:], pcapLinkTypeRaw)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	recordHeader := make([]byte, 16)
+	for _, packet := range packets {
+		binary.LittleEndian.PutUint32(recordHeader[0:], uint32(packet.Time.Unix()))
+		binary.LittleEndian.PutUint32(recordHeader[4:], uint32(packet.Time.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(recordHeader[8:], uint32(len(packet.Data)))
+		binary.LittleEndian.PutUint32(recordHeader[12:], uint32(len(packet.Data)))
+		if _, err := w.Write(recordHeader); err != nil {
+			return err
+		}
+		if _, err := w.Write(packet.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
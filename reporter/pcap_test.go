@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestSynthesizeConnection(t *testing.T) {
+	capture := &Capture{
+		BeginTime: parseTime("2017-12-07T23:40:36Z"),
+		EndTime:   parseTime("2017-12-07T23:40:38Z"),
+		Frames: []Frame{
+			{Time: parseTime("2017-12-07T23:40:37Z"), IsRead: false, Data: []byte("hello")},
+			{Time: parseTime("2017-12-07T23:40:37Z"), IsRead: true, Data: []byte("world!")},
+		},
+	}
+	clientIP, serverIP := net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")
+	packets := synthesizeConnection(clientIP, serverIP, 50000, capture, false)
+
+	expected := []struct {
+		fromClient bool
+		flags      uint8
+		seq, ack   uint32
+		payload    string
+	}{
+		{true, tcpFlagSYN, 0, 0, ""},
+		{false, tcpFlagSYN | tcpFlagACK, 0, 1, ""},
+		{true, tcpFlagACK, 1, 1, ""},
+		{true, tcpFlagPSH | tcpFlagACK, 1, 1, "hello"},
+		{false, tcpFlagPSH | tcpFlagACK, 1, 6, "world!"},
+		{true, tcpFlagFIN | tcpFlagACK, 6, 7, ""},
+		{false, tcpFlagFIN | tcpFlagACK, 7, 7, ""},
+		{true, tcpFlagACK, 7, 8, ""},
+	}
+	if len(packets) != len(expected) {
+		t.Fatalf("expected %d packets, got %d", len(expected), len(packets))
+	}
+	for i, e := range expected {
+		data := packets[i].Data
+		if internetChecksum(0, data[:20]) != 0 {
+			t.Errorf("packet %d: invalid IPv4 header checksum", i)
+		}
+		srcIP, dstIP := net.IP(data[12:16]), net.IP(data[16:20])
+		if e.fromClient != srcIP.Equal(clientIP) {
+			t.Errorf("packet %d: unexpected source %v -> %v", i, srcIP, dstIP)
+		}
+		segment := data[20:]
+		if tcpChecksum(srcIP, dstIP, segment) != 0 {
+			t.Errorf("packet %d: invalid TCP checksum", i)
+		}
+		seq := binary.BigEndian.Uint32(segment[4:])
+		ack := binary.BigEndian.Uint32(segment[8:])
+		if seq != e.seq || ack != e.ack {
+			t.Errorf("packet %d: expected seq=%d ack=%d, got seq=%d ack=%d", i, e.seq, e.ack, seq, ack)
+		}
+		if segment[13] != e.flags {
+			t.Errorf("packet %d: expected flags %#x, got %#x", i, e.flags, segment[13])
+		}
+		if payload := string(segment[20:]); payload != e.payload {
+			t.Errorf("packet %d: expected payload %q, got %q", i, e.payload, payload)
+		}
+	}
+
+	// the server view swaps the direction of frames.
+	packets = synthesizeConnection(clientIP, serverIP, 50000, capture, true)
+	if src := net.IP(packets[3].Data[12:16]); !src.Equal(serverIP) {
+		t.Errorf("expected first frame from server, got %v", src)
+	}
+}
+
+func TestSynthesizeConnectionIPv6(t *testing.T) {
+	capture := &Capture{
+		Frames: []Frame{
+			{Time: parseTime("2017-12-07T23:40:37Z"), Data: []byte("hello")},
+		},
+	}
+	packets := synthesizeConnection(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), 50000, capture, true)
+	data := packets[3].Data
+	if data[0]>>4 != 6 {
+		t.Fatalf("expected IPv6 packet, got version %d", data[0]>>4)
+	}
+	if length := binary.BigEndian.Uint16(data[4:]); int(length) != len(data)-40 {
+		t.Errorf("unexpected payload length %d", length)
+	}
+	if tcpChecksum(net.IP(data[8:24]), net.IP(data[24:40]), data[40:]) != 0 {
+		t.Error("invalid TCP checksum")
+	}
+}
+
+func TestSyntheticClientPort(t *testing.T) {
+	seen := make(map[uint16]bool)
+	for _, serverView := range []bool{false, true} {
+		for connection := 0; connection < 50; connection++ {
+			for number := 1; number <= 4; number++ {
+				port := syntheticClientPort(number, connection, serverView)
+				if port < syntheticClientPortBase {
+					t.Fatalf("port %d of subtest %d, connection %d is not ephemeral", port, number, connection)
+				}
+				if seen[port] {
+					t.Fatalf("port %d of subtest %d, connection %d is reused", port, number, connection)
+				}
+				seen[port] = true
+			}
+		}
+	}
+}
+
+func TestWritePcap(t *testing.T) {
+	var buffer bytes.Buffer
+	packets := []pcapPacket{
+		{Time: parseTime("2017-12-07T23:40:37Z"), Data: []byte{1, 2, 3}},
+	}
+	if err := writePcap(&buffer, packets); err != nil {
+		t.Fatalf("writePcap failed: %v", err)
+	}
+	data := buffer.Bytes()
+	if len(data) != 24+16+3 {
+		t.Fatalf("unexpected pcap size %d", len(data))
+	}
+	if !bytes.Equal(data[:4], []byte{0xd4, 0xc3, 0xb2, 0xa1}) {
+		t.Errorf("unexpected magic %x", data[:4])
+	}
+	if linkType := binary.LittleEndian.Uint32(data[20:]); linkType != pcapLinkTypeRaw {
+		t.Errorf("unexpected link type %d", linkType)
+	}
+	if ts := binary.LittleEndian.Uint32(data[24:]); ts != uint32(packets[0].Time.Unix()) {
+		t.Errorf("unexpected timestamp %d", ts)
+	}
+}
+
+func TestWritePcapng(t *testing.T) {
+	results := []*SubtestResult{{
+		Subtest: &Subtest{Number: 1, MaxTLSVersion: 0x0303},
+		ClientCapture: &ClientCapture{Capture: Capture{
+			Frames: []Frame{{Time: parseTime("2017-12-07T23:40:37Z"), Data: []byte("hello")}},
+			KeyLog: "CLIENT_RANDOM aa bb\n\n",
+		}},
+		ServerCaptures: []*ServerCapture{{
+			Capture: Capture{
+				Frames: []Frame{{Time: parseTime("2017-12-07T23:40:37Z"), IsRead: true, Data: []byte("hello")}},
+				KeyLog: "CLIENT_RANDOM aa bb\n",
+			},
+			ClientIP: net.ParseIP("192.0.2.1"),
+			ServerIP: net.ParseIP("192.0.2.2"),
+		}},
+	}}
+	var buffer bytes.Buffer
+	if err := writePcapng(&buffer, results); err != nil {
+		t.Fatalf("writePcapng failed: %v", err)
+	}
+
+	// walk all blocks and check that their lengths are consistent.
+	blockCounts := make(map[uint32]int)
+	data := buffer.Bytes()
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatalf("truncated block: %x", data)
+		}
+		blockType := binary.LittleEndian.Uint32(data[0:])
+		length := binary.LittleEndian.Uint32(data[4:])
+		if length%4 != 0 || int(length) > len(data) ||
+			binary.LittleEndian.Uint32(data[length-4:]) != length {
+			t.Fatalf("invalid block length %d for type %#x", length, blockType)
+		}
+		if blockType == pcapngBlockDecryptionSecrets {
+			secrets := string(data[16 : 16+binary.LittleEndian.Uint32(data[12:])])
+			if secrets != "CLIENT_RANDOM aa bb\n" {
+				t.Errorf("unexpected secrets %q", secrets)
+			}
+		}
+		blockCounts[blockType]++
+		data = data[length:]
+	}
+	if blockCounts[pcapngBlockInterfaceDescription] != 2 {
+		t.Errorf("expected two interfaces, got %d", blockCounts[pcapngBlockInterfaceDescription])
+	}
+	if blockCounts[pcapngBlockDecryptionSecrets] != 1 {
+		t.Errorf("expected one secrets block, got %d", blockCounts[pcapngBlockDecryptionSecrets])
+	}
+	// 7 packets for the client view and 7 packets for the server view.
+	if blockCounts[pcapngBlockEnhancedPacket] != 14 {
+		t.Errorf("expected 14 packets, got %d", blockCounts[pcapngBlockEnhancedPacket])
+	}
+}
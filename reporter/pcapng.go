@@ -0,0 +1,177 @@
+// pcapng export. Unlike libpcap, this format can combine the client and server
+// views in one file and embed the TLS key log for decryption.
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// pcapng block types
+const (
+	pcapngBlockSectionHeader        uint32 = 0x0a0d0d0a
+	pcapngBlockInterfaceDescription uint32 = 0x00000001
+	pcapngBlockEnhancedPacket       uint32 = 0x00000006
+	pcapngBlockDecryptionSecrets    uint32 = 0x0000000a
+)
+
+// pcapng option codes
+const (
+	pcapngOptionEndOfOpt uint16 = 0
+	pcapngOptionComment  uint16 = 1
+	pcapngOptionIfName   uint16 = 2
+)
+
+// Secrets type for a NSS Key Log in a Decryption Secrets Block.
+const pcapngSecretsTLSKeyLog uint32 = 0x544c534b
+
+// Interface IDs, in order of their description blocks.
+const (
+	pcapngInterfaceClient uint32 = iota
+	pcapngInterfaceServer
+)
+
+// pcapngPacket is a packet that belongs to a pcapng interface.
+type pcapngPacket struct {
+	pcapPacket
+	InterfaceID uint32
+	Comment     string
+}
+
+// pcapngOption encodes an option, padding the value to 32 bits.
+func pcapngOption(code uint16, value []byte) []byte {
+	option := make([]byte, 4+pcapngPadLength(len(value)))
+	binary.LittleEndian.PutUint16(option[0:], code)
+	binary.LittleEndian.PutUint16(option[2:], uint16(len(value)))
+	copy(option[4:], value)
+	return option
+}
+
+func pcapngPadLength(n int) int {
+	return (n + 3) &^ 3
+}
+
+// writePcapngBlock writes a block with the given body and options.
+func writePcapngBlock(w io.Writer, blockType uint32, body []byte, options ...[]byte) error {
+	if len(options) > 0 {
+		options = append(options, pcapngOption(pcapngOptionEndOfOpt, nil))
+	}
+	length := 12 + len(body)
+	for _, option := range options {
+		length += len(option)
+	}
+	block := make([]byte, 0, length)
+	block = appendUint32(block, blockType)
+	block = appendUint32(block, uint32(length))
+	block = append(block, body...)
+	for _, option := range options {
+		block = append(block, option...)
+	}
+	block = appendUint32(block, uint32(length))
+	_, err := w.Write(block)
+	return err
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+// uniqueKeyLogLines returns the non-empty lines of all key logs, in order and
+// without duplicates.
+func uniqueKeyLogLines(keyLogs ...string) []string {
+	var lines []string
+	seen := make(map[string]bool)
+	for _, keyLog := range keyLogs {
+		for _, line := range strings.Split(keyLog, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || seen[line] {
+				continue
+			}
+			seen[line] = true
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// subtestKeyLogs returns the key logs of all captures of a subtest.
+func subtestKeyLogs(result *SubtestResult) []string {
+	var keyLogs []string
+	if result.ClientCapture != nil {
+		keyLogs = append(keyLogs, result.ClientCapture.KeyLog)
+	}
+	for _, capture := range result.ServerCaptures {
+		keyLogs = append(keyLogs, capture.KeyLog)
+	}
+	return keyLogs
+}
+
+// writePcapng writes the client and server views of all subtests as separate
+// interfaces. Key logs are embedded as Decryption Secrets Blocks.
+func writePcapng(w io.Writer, results []*SubtestResult) error {
+	// Section Header Block: byte-order magic, version 1.0, unknown length.
+	header := make([]byte, 16)
+	binary.LittleEndian.PutUint32(header[0:], 0x1a2b3c4d)
+	binary.LittleEndian.PutUint16(header[4:], 1)
+	binary.LittleEndian.PutUint64(header[8:], ^uint64(0))
+	if err := writePcapngBlock(w, pcapngBlockSectionHeader, header); err != nil {
+		return err
+	}
+
+	// Interface Description Blocks (default microsecond resolution).
+	for _, name := range []string{"client", "server"} {
+		description := make([]byte, 8)
+		binary.LittleEndian.PutUint16(description[0:], pcapLinkTypeRaw)
+		binary.LittleEndian.PutUint32(description[4:], pcapSnapLen)
+		if err := writePcapngBlock(w, pcapngBlockInterfaceDescription, description,
+			pcapngOption(pcapngOptionIfName, []byte(name))); err != nil {
+			return err
+		}
+	}
+
+	var packets []pcapngPacket
+	for _, result := range results {
+		lines := uniqueKeyLogLines(subtestKeyLogs(result)...)
+		if len(lines) > 0 {
+			secrets := []byte(strings.Join(lines, "\n") + "\n")
+			body := make([]byte, 8+pcapngPadLength(len(secrets)))
+			binary.LittleEndian.PutUint32(body[0:], pcapngSecretsTLSKeyLog)
+			binary.LittleEndian.PutUint32(body[4:], uint32(len(secrets)))
+			copy(body[8:], secrets)
+			if err := writePcapngBlock(w, pcapngBlockDecryptionSecrets, body); err != nil {
+				return err
+			}
+		}
+
+		comment := fmt.Sprintf("subtest %d, max TLS version %#04x, IPv6: %t",
+			result.Number, result.MaxTLSVersion, result.IsIPv6)
+		for _, packet := range clientConnectionPackets(result) {
+			packets = append(packets, pcapngPacket{packet, pcapngInterfaceClient, comment})
+		}
+		for _, packet := range serverConnectionPackets(result) {
+			packets = append(packets, pcapngPacket{packet, pcapngInterfaceServer, comment})
+		}
+	}
+	sort.SliceStable(packets, func(i, j int) bool {
+		return packets[i].Time.Before(packets[j].Time)
+	})
+
+	for _, packet := range packets {
+		timestamp := uint64(packet.Time.UnixNano() / 1000)
+		body := make([]byte, 20+pcapngPadLength(len(packet.Data)))
+		binary.LittleEndian.PutUint32(body[0:], packet.InterfaceID)
+		binary.LittleEndian.PutUint32(body[4:], uint32(timestamp>>32))
+		binary.LittleEndian.PutUint32(body[8:], uint32(timestamp))
+		binary.LittleEndian.PutUint32(body[12:], uint32(len(packet.Data)))
+		binary.LittleEndian.PutUint32(body[16:], uint32(len(packet.Data)))
+		copy(body[20:], packet.Data)
+		if err := writePcapngBlock(w, pcapngBlockEnhancedPacket, body,
+			pcapngOption(pcapngOptionComment, []byte(packet.Comment))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
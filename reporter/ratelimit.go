@@ -0,0 +1,119 @@
+// Token bucket rate limiting per client address.
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errRateLimited = gin.H{"error": "too many requests"}
+
+// Interval after which buckets that are full again are forgotten.
+const rateLimitCleanupInterval = time.Minute
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter tracks a token bucket for every key.
+type rateLimiter struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	lock        sync.Mutex
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+	now         func() time.Time
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	burst := limit.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:    float64(limit.PerMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// refill adds the tokens that became available since the last update.
+func (rl *rateLimiter) refill(bucket *tokenBucket, now time.Time) {
+	elapsed := now.Sub(bucket.updated).Seconds()
+	bucket.tokens = math.Min(rl.burst, bucket.tokens+elapsed*rl.rate)
+	bucket.updated = now
+}
+
+// Allow takes a token for the given key if available. Otherwise it returns
+// false and the time after which the next token becomes available.
+func (rl *rateLimiter) Allow(key string) (bool, time.Duration) {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	now := rl.now()
+
+	if now.Sub(rl.lastCleanup) >= rateLimitCleanupInterval {
+		for k, bucket := range rl.buckets {
+			if rl.refill(bucket, now); bucket.tokens >= rl.burst {
+				delete(rl.buckets, k)
+			}
+		}
+		rl.lastCleanup = now
+	}
+
+	bucket := rl.buckets[key]
+	if bucket == nil {
+		bucket = &tokenBucket{tokens: rl.burst, updated: now}
+		rl.buckets[key] = bucket
+	}
+	rl.refill(bucket, now)
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	wait := (1 - bucket.tokens) / rl.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// rateLimitKey groups IPv6 clients by their /64 prefix since a single host
+// usually has a whole prefix available.
+func rateLimitKey(ip net.IP) string {
+	if ip.To4() != nil {
+		return ip.String()
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+// makeRateLimited returns a middleware that limits the number of requests per
+// client address. A zero PerMinute value disables the limit.
+func makeRateLimited(limit RateLimit) gin.HandlerFunc {
+	if limit.PerMinute <= 0 {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+
+	limiter := newRateLimiter(limit)
+	return func(c *gin.Context) {
+		clientIP := net.ParseIP(parseHost(c.Request.RemoteAddr))
+		if clientIP == nil {
+			c.Next()
+			return
+		}
+		if ok, wait := limiter.Allow(rateLimitKey(clientIP)); !ok {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, errRateLimited)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := parseTime("2017-12-07T23:40:36Z")
+	rl := newRateLimiter(RateLimit{PerMinute: 6, Burst: 2})
+	rl.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := rl.Allow("a"); !ok {
+			t.Fatalf("request %d within burst was denied", i+1)
+		}
+	}
+	ok, wait := rl.Allow("a")
+	if ok {
+		t.Fatal("request exceeding burst was allowed")
+	}
+	if wait != 10*time.Second {
+		t.Errorf("expected to wait 10s, got %v", wait)
+	}
+	if ok, _ := rl.Allow("b"); !ok {
+		t.Error("other keys must not be limited")
+	}
+
+	now = now.Add(10 * time.Second)
+	if ok, _ := rl.Allow("a"); !ok {
+		t.Error("request after refill was denied")
+	}
+
+	// buckets that are full again are forgotten.
+	now = now.Add(time.Hour)
+	rl.Allow("c")
+	if len(rl.buckets) != 1 {
+		t.Errorf("expected stale buckets to be removed, got %d", len(rl.buckets))
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	for ip, expected := range map[string]string{
+		"192.0.2.1":          "192.0.2.1",
+		"::ffff:192.0.2.1":   "192.0.2.1",
+		"2001:db8:1:2:3::4":  "2001:db8:1:2::/64",
+		"2001:db8:1:2:ff::4": "2001:db8:1:2::/64",
+	} {
+		if key := rateLimitKey(net.ParseIP(ip)); key != expected {
+			t.Errorf("%s: expected %s, got %s", ip, expected, key)
+		}
+	}
+}
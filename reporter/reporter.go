@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"crypto/subtle"
 	"database/sql"
@@ -24,16 +25,24 @@ type reporter struct {
 	*gin.Engine
 	db     *sql.DB
 	config *Config
+
+	// invoked when a test is concluded.
+	testFinalizers []TestFinalizer
+
+	// results of anonymous tests.
+	ephemeralTests *ephemeralStore
+
+	// known middlebox fingerprints (nil if not configured).
+	middleboxes *middleboxDatabase
+
+	// certificate of the test service, for detecting substitutions.
+	dummyCert *CertificateLoader
 }
 
 var errTestNotFound = gin.H{"error": "test not found"}
 var errSubTestNotFound = gin.H{"error": "subtest not found"}
 var errCsrf = gin.H{"error": "missing X-Requested-With header"}
 
-func stubHandler(c *gin.Context) {
-	c.String(http.StatusNotImplemented, "not implemented yet")
-}
-
 // csrfProtection requires the X-Requested-With header to be set for requests
 // with non-safe methods.
 func csrfProtection(c *gin.Context) {
@@ -46,47 +55,113 @@ func csrfProtection(c *gin.Context) {
 	c.Next()
 }
 
-func makeAuthRequired(apiKeyHash string) gin.HandlerFunc {
-	apiKeyHashBytes, _ := hex.DecodeString(apiKeyHash)
-	if len(apiKeyHashBytes) != sha256.Size {
-		log.Println("Configured ReporterApiKeyHash is invalid, disabling access.")
-		apiKeyHashBytes = nil
+// Context key for the name of the API key that authorized the request.
+const apiKeyNameKey = "apiKeyName"
+
+// Name that is recorded instead of an API key name when a participant removes
+// a test with its deletion token.
+const deletionTokenKeyName = "(deletion token)"
+
+type apiKey struct {
+	name string
+	hash []byte
+	role string
+}
+
+// parseApiKeys returns the valid configured API keys.
+func parseApiKeys(keys []ApiKey) []apiKey {
+	var validKeys []apiKey
+	for _, key := range keys {
+		hash, _ := hex.DecodeString(key.Hash)
+		if len(hash) != sha256.Size {
+			log.Printf("Configured hash of API key %q is invalid, ignoring key.", key.Name)
+			continue
+		}
+		if key.Role != roleRead && key.Role != roleWrite {
+			log.Printf("Configured role of API key %q is invalid, ignoring key.", key.Name)
+			continue
+		}
+		validKeys = append(validKeys, apiKey{key.Name, hash, key.Role})
 	}
+	return validKeys
+}
+
+// hasRole returns whether a key with the given role has the required role.
+func hasRole(role, requiredRole string) bool {
+	return role == requiredRole || role == roleWrite
+}
+
+// makeAuthRequired requires an API key with the given role. The name of the
+// key is stored in the context.
+func makeAuthRequired(keys []ApiKey, requiredRole string) gin.HandlerFunc {
+	validKeys := parseApiKeys(keys)
 
 	return func(c *gin.Context) {
 		header := c.GetHeader("X-API-Secret")
 		userKeyHash := sha256.Sum256([]byte(header))
-		// if no key is configured in the application, the following
-		// will fail to match.
-		if subtle.ConstantTimeCompare(apiKeyHashBytes, userKeyHash[:]) != 1 {
+		// compare against all keys to avoid leaking which key matched.
+		// If no keys are configured, this will fail to match.
+		var matchedKey *apiKey
+		for i := range validKeys {
+			if subtle.ConstantTimeCompare(validKeys[i].hash, userKeyHash[:]) == 1 {
+				matchedKey = &validKeys[i]
+			}
+		}
+		if matchedKey == nil || !hasRole(matchedKey.role, requiredRole) {
 			c.AbortWithStatus(http.StatusForbidden)
 			return
 		}
+		c.Set(apiKeyNameKey, matchedKey.name)
+		log.Printf("API key %q: %s %s", matchedKey.name, c.Request.Method, c.Request.URL.Path)
 		c.Next()
 	}
 }
 
-func newReporter(db *sql.DB, config *Config) *reporter {
+func newReporter(db *sql.DB, config *Config, ephemeralTests *ephemeralStore, middleboxes *middleboxDatabase, dummyCert *CertificateLoader) *reporter {
 	router := gin.Default()
-	rep := &reporter{router, db, config}
+	rep := &reporter{
+		Engine:         router,
+		db:             db,
+		config:         config,
+		testFinalizers: defaultTestFinalizers,
+		ephemeralTests: ephemeralTests,
+		middleboxes:    middleboxes,
+		dummyCert:      dummyCert,
+	}
+	if middleboxes != nil {
+		finalizers := append([]TestFinalizer{}, defaultTestFinalizers...)
+		rep.testFinalizers = append(finalizers, makeMiddleboxFinalizer(middleboxes))
+	}
 
 	v1 := router.Group(config.ReporterApiPrefix)
 	v1.Use(csrfProtection)
 	{
-		v1.POST("/tests", rep.createTest)
-		v1.PATCH("/tests/:testid", rep.updateTest)
-		v1.PUT("/tests/:testid/subtests/:number/clientresult", rep.addClientResult)
+		createLimit := makeRateLimited(config.CreateTestRateLimit)
+		submitLimit := makeRateLimited(config.SubmitRateLimit)
+		v1.POST("/tests", createLimit, rep.createTest)
+		v1.PATCH("/tests/:testid", submitLimit, rep.updateTest)
+		v1.PUT("/tests/:testid/subtests/:number/clientresult", submitLimit, rep.addClientResult)
+		v1.GET("/tests/:testid/result", rep.getEphemeralResult)
+	}
+	requireWrite := makeAuthRequired(config.ReporterApiKeys, roleWrite)
+	// participants can remove their own test, others need an API key.
+	v1.DELETE("/tests/:testid", rep.auditAccess, rep.allowDeletionToken, requireWrite, rep.removeTest)
+	readers := v1.Group("/", rep.auditAccess, makeAuthRequired(config.ReporterApiKeys, roleRead))
+	{
+		readers.GET("/tests", rep.listTests)
+		readers.GET("/tests/:testid", rep.listTest)
+		readers.GET("/tests/:testid/subtests", rep.listSubtests)
+		readers.GET("/tests/:testid/subtests/:number", rep.listSubtest)
+		readers.GET("/tests/:testid/subtests/:number/diff", rep.getClientHelloDiff)
+		readers.GET("/tests/:testid/client.pcap", rep.getClientPcap)
+		readers.GET("/tests/:testid/server.pcap", rep.getServerPcap)
+		readers.GET("/tests/:testid/capture.pcapng", rep.getPcapng)
+		readers.GET("/tests/:testid/keylog.txt", rep.getKeyLog)
+		readers.GET("/stats", rep.getStats)
 	}
-	authorized := v1.Group("/", makeAuthRequired(config.ReporterApiKeyHash))
+	writers := v1.Group("/", rep.auditAccess, requireWrite)
 	{
-		authorized.GET("/tests", rep.listTests)
-		authorized.DELETE("/tests/:testid", rep.removeTest)
-		authorized.GET("/tests/:testid", rep.listTest)
-		authorized.GET("/tests/:testid/subtests", stubHandler)
-		authorized.GET("/tests/:testid/subtests/:number", stubHandler)
-		authorized.GET("/tests/:testid/client.pcap", stubHandler)
-		authorized.GET("/tests/:testid/server.pcap", stubHandler)
-		authorized.GET("/tests/:testid/keylog.txt", stubHandler)
+		writers.GET("/audit", rep.listAuditLog)
 	}
 
 	if config.ReporterStaticFilesRoot != "" {
@@ -157,9 +232,46 @@ func (*reporter) getSubtestNumber(c *gin.Context) (int, bool) {
 	if n, err := strconv.Atoi(c.Param("number")); err == nil {
 		return n, true
 	}
+	c.JSON(http.StatusNotFound, errSubTestNotFound)
 	return 0, false
 }
 
+// getTestKey looks up the internal TestID for the test given in the request.
+func (r *reporter) getTestKey(c *gin.Context) (int, bool) {
+	testID, ok := r.getTestID(c)
+	if !ok {
+		return 0, false
+	}
+	var testIDKey int
+	err := r.db.QueryRow(`
+	SELECT id FROM tests WHERE test_id = $1
+	`, testID).Scan(&testIDKey)
+	switch {
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, errTestNotFound)
+		return 0, false
+	case err != nil:
+		r.dbError(c, err)
+		return 0, false
+	}
+	return testIDKey, true
+}
+
+// getSubtestResults retrieves all subtests and captures for the test given in
+// the request.
+func (r *reporter) getSubtestResults(c *gin.Context) ([]*SubtestResult, bool) {
+	testIDKey, ok := r.getTestKey(c)
+	if !ok {
+		return nil, false
+	}
+	results, err := QuerySubtestResults(r.db, testIDKey)
+	if err != nil {
+		r.dbError(c, err)
+		return nil, false
+	}
+	return results, true
+}
+
 // checkTestEditAllowed checks whether a test exists and whether it is allowed
 // to be modified given the elapsed time. If edits are allowed, the internal
 // TestID is and true is returned.
@@ -217,8 +329,8 @@ func (r *reporter) createTest(c *gin.Context) {
 			})
 			return
 		}
-		// TODO rate limiting
 
+		deletionToken := GenerateToken()
 		test := &Test{
 			ClientIP:      net.ParseIP(parseHost(c.Request.RemoteAddr)),
 			ClientVersion: json.ClientVersion,
@@ -226,13 +338,21 @@ func (r *reporter) createTest(c *gin.Context) {
 			UserAgent:     json.UserAgent,
 			IsPending:     true,
 		}
+		test.DeletionTokenHash = hashDeletionToken(deletionToken)
 		subtestSpecs := r.config.Subtests
 
 		anonymousValue, anonymousSet := c.GetQuery("anonymous")
 		if anonymousValue == "" && anonymousSet {
 			// create surrogate identifier (it is needed to create a
-			// random domain)
-			test.TestID = "otr-" + GenerateUUIDv4()
+			// random domain), results are only kept in memory.
+			test.TestID = ephemeralTestPrefix + GenerateUUIDv4()
+			err := r.ephemeralTests.Create(test.TestID, test.ClientIP, subtestSpecs)
+			if err != nil {
+				c.JSON(http.StatusServiceUnavailable, gin.H{
+					"error": err.Error(),
+				})
+				return
+			}
 		} else {
 			tx, err := r.db.Begin()
 			if err != nil {
@@ -253,10 +373,11 @@ func (r *reporter) createTest(c *gin.Context) {
 			// subtests
 			for _, spec := range subtestSpecs {
 				subtest := &Subtest{
-					TestID:        test.ID,
-					Number:        spec.Number,
-					MaxTLSVersion: spec.MaxTLSVersion,
-					IsIPv6:        spec.IsIPv6,
+					TestID:          test.ID,
+					Number:          spec.Number,
+					MaxTLSVersion:   spec.MaxTLSVersion,
+					IsIPv6:          spec.IsIPv6,
+					ExpectedVersion: spec.expectedTLSVersion(),
 				}
 				if err = subtest.Create(tx); err != nil {
 					r.dbError(c, err)
@@ -268,11 +389,37 @@ func (r *reporter) createTest(c *gin.Context) {
 			tx = nil
 		}
 
-		c.JSON(http.StatusCreated, gin.H{
-			"test_id":  test.TestID,
-			"subtests": subtestSpecs,
-		})
+		response := gin.H{
+			"test_id":   test.TestID,
+			"subtests":  subtestSpecs,
+			"spki_pins": r.testServicePins(),
+		}
+		// anonymous tests are not stored, there is nothing to remove.
+		if test.ID != 0 {
+			response["deletion_token"] = deletionToken
+		}
+		c.JSON(http.StatusCreated, response)
+	}
+}
+
+// testServicePins returns the SPKI pins that clients can use to check the
+// certificate of the test service.
+func (r *reporter) testServicePins() []string {
+	pins := []string{}
+	if r.dummyCert == nil {
+		return pins
+	}
+	served, err := r.dummyCert.Load()
+	if served == nil {
+		log.Printf("Cannot determine SPKI pins: %s", err)
+		return pins
+	}
+	pin, err := leafSPKIPin(served)
+	if err != nil {
+		log.Printf("Cannot determine SPKI pins: %s", err)
+		return pins
 	}
+	return append(pins, pin)
 }
 
 type updateTestRequest struct {
@@ -295,8 +442,19 @@ func (r *reporter) updateTest(c *gin.Context) {
 			return
 		}
 
+		tx, err := r.db.Begin()
+		if err != nil {
+			r.dbError(c, err)
+			return
+		}
+		defer func() {
+			if tx != nil {
+				tx.Rollback()
+			}
+		}()
+
 		// update fields if allowed
-		result, err := r.db.Exec(`
+		result, err := tx.Exec(`
 		UPDATE tests
 		SET
 			user_comment = COALESCE($2, user_comment),
@@ -319,18 +477,42 @@ func (r *reporter) updateTest(c *gin.Context) {
 			return
 		}
 
+		// the client has completed the test.
+		if json.IsPending != nil && !*json.IsPending {
+			err = FinalizeTest(tx, testIDKey, r.testFinalizers)
+			if err != nil {
+				r.dbError(c, err)
+				return
+			}
+		}
+
+		if err = tx.Commit(); err != nil {
+			r.dbError(c, err)
+			return
+		}
+		tx = nil
+
 		c.Status(http.StatusNoContent)
 	}
 }
 
 type addClientResultRequest struct {
-	Number           int       `json:"number"`
-	BeginTime        time.Time `json:"begin_time"`
-	EndTime          time.Time `json:"end_time"`
-	ActualTLSVersion uint16    `json:"actual_tls_version"`
-	Frames           []Frame   `json:"frames"`
-	KeyLog           string    `json:"key_log"`
-	HasFailed        bool      `json:"has_failed"`
+	Number            int       `json:"number"`
+	BeginTime         time.Time `json:"begin_time"`
+	EndTime           time.Time `json:"end_time"`
+	ActualTLSVersion  uint16    `json:"actual_tls_version"`
+	Frames            []Frame   `json:"frames"`
+	KeyLog            string    `json:"key_log"`
+	HasFailed         bool      `json:"has_failed"`
+	PeerCertificates  [][]byte  `json:"peer_certificates"`
+	SPKIHashes        []string  `json:"spki_hashes"`
+	PinStatus         string    `json:"pin_status"`
+	CipherSuite       uint16    `json:"cipher_suite"`
+	KeyExchangeGroup  uint16    `json:"key_exchange_group"`
+	ALPNProtocol      string    `json:"alpn_protocol"`
+	IsResumed         bool      `json:"is_resumed"`
+	HelloRetryRequest bool      `json:"hello_retry_request"`
+	DowngradeSentinel bool      `json:"downgrade_sentinel"`
 }
 
 func addClientResultRequestToClientCapture(r *addClientResultRequest) (*ClientCapture, error) {
@@ -342,21 +524,57 @@ func addClientResultRequestToClientCapture(r *addClientResultRequest) (*ClientCa
 			return nil, fmt.Errorf("Frame number %d has no data", frameNo+1)
 		}
 	}
+	if len(r.SPKIHashes) != len(r.PeerCertificates) {
+		return nil, errors.New("SPKIHashes must match PeerCertificates")
+	}
+	switch r.PinStatus {
+	case "", pinStatusMatch, pinStatusMismatch:
+	default:
+		return nil, errors.New("invalid PinStatus")
+	}
 
-	// unpopulated fields: ID, CreatedAt, SubtestID
+	// unpopulated fields: ID, CreatedAt, SubtestID, CertSubstituted
 	return &ClientCapture{
-		Capture{
-			BeginTime:        r.BeginTime,
-			EndTime:          r.EndTime,
-			ActualTLSVersion: r.ActualTLSVersion,
-			Frames:           r.Frames,
-			KeyLog:           r.KeyLog,
-			HasFailed:        r.HasFailed,
+		Capture: Capture{
+			BeginTime:         r.BeginTime,
+			EndTime:           r.EndTime,
+			ActualTLSVersion:  r.ActualTLSVersion,
+			Frames:            r.Frames,
+			KeyLog:            r.KeyLog,
+			HasFailed:         r.HasFailed,
+			CipherSuite:       r.CipherSuite,
+			KeyExchangeGroup:  r.KeyExchangeGroup,
+			ALPNProtocol:      r.ALPNProtocol,
+			IsResumed:         r.IsResumed,
+			HelloRetryRequest: r.HelloRetryRequest,
 		},
+		PeerCertificates:  r.PeerCertificates,
+		SPKIHashes:        r.SPKIHashes,
+		PinStatus:         r.PinStatus,
+		DowngradeSentinel: r.DowngradeSentinel,
 	}, nil
 }
 
+// checkPeerCertificates compares the certificate received by the client with
+// the certificate of the test service.
+func (r *reporter) checkPeerCertificates(capture *ClientCapture) {
+	if r.dummyCert == nil {
+		return
+	}
+	// if reloading failed, the previous certificate is still served.
+	served, err := r.dummyCert.Load()
+	if served == nil {
+		log.Printf("Cannot check peer certificates: %s", err)
+		return
+	}
+	capture.CertSubstituted = isCertificateSubstituted(capture.PeerCertificates, served)
+}
+
 func (r *reporter) addClientResult(c *gin.Context) {
+	if isEphemeralTestID(c.Param("testid")) {
+		r.addEphemeralClientResult(c)
+		return
+	}
 	testIDKey, ok := r.checkTestEditAllowed(c)
 	if !ok {
 		return
@@ -376,9 +594,33 @@ func (r *reporter) addClientResult(c *gin.Context) {
 			return
 		}
 
+		tx, err := r.db.Begin()
+		if err != nil {
+			r.dbError(c, err)
+			return
+		}
+		defer func() {
+			if tx != nil {
+				tx.Rollback()
+			}
+		}()
+
+		// the test could have been concluded by a concurrent request.
+		isPending, err := lockPendingTest(tx, testIDKey)
+		if err != nil {
+			r.dbError(c, err)
+			return
+		}
+		if !isPending {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "test can no longer be modified",
+			})
+			return
+		}
+
 		// check for duplicate tests
 		var clientCaptureCount sql.NullInt64
-		err = r.db.QueryRow(`
+		err = tx.QueryRow(`
 		SELECT
 			subtests.id,
 			client_captures.id
@@ -404,18 +646,45 @@ func (r *reporter) addClientResult(c *gin.Context) {
 			return
 		}
 
-		err = clientCapture.Create(r.db)
+		r.checkPeerCertificates(clientCapture)
+		err = clientCapture.Create(tx)
 		if err != nil {
 			r.dbError(c, err)
 			return
 		}
 
-		// TODO set IsPending if all subtests are complete
+		// conclude the test once all subtests are complete.
+		missing, err := countMissingClientResults(tx, testIDKey)
+		if err != nil {
+			r.dbError(c, err)
+			return
+		}
+		if missing == 0 {
+			err = FinalizeTest(tx, testIDKey, r.testFinalizers)
+			if err != nil {
+				r.dbError(c, err)
+				return
+			}
+		}
+
+		if err = tx.Commit(); err != nil {
+			r.dbError(c, err)
+			return
+		}
+		tx = nil
 	}
 }
 
 func (r *reporter) listTests(c *gin.Context) {
-	rows, err := QueryTests(r.db)
+	listQuery, err := parseTestListQuery(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	extraQuery, args := listQuery.SQL()
+	rows, err := QueryTests(r.db, append([]interface{}{extraQuery}, args...)...)
 	if err != nil {
 		r.dbError(c, err)
 		return
@@ -437,7 +706,15 @@ func (r *reporter) listTests(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, tests)
+	var next interface{}
+	if len(tests) > listQuery.limit {
+		tests = tests[:listQuery.limit]
+		next = encodeCursor(tests[len(tests)-1])
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"result": tests,
+		"next":   next,
+	})
 }
 
 func (r *reporter) listTest(c *gin.Context) {
@@ -471,6 +748,222 @@ func (r *reporter) listTest(c *gin.Context) {
 	c.JSON(http.StatusNotFound, errTestNotFound)
 }
 
+// captureSummary describes a capture without its frames and key log.
+type captureSummary struct {
+	CreatedAt         time.Time `json:"created_at"`
+	BeginTime         time.Time `json:"begin_time"`
+	EndTime           time.Time `json:"end_time"`
+	ActualTLSVersion  uint16    `json:"actual_tls_version"`
+	HasFailed         bool      `json:"has_failed"`
+	FrameCount        int       `json:"frame_count"`
+	CipherSuite       uint16    `json:"cipher_suite"`
+	KeyExchangeGroup  uint16    `json:"key_exchange_group"`
+	ALPNProtocol      string    `json:"alpn_protocol"`
+	IsResumed         bool      `json:"is_resumed"`
+	HelloRetryRequest bool      `json:"hello_retry_request"`
+}
+
+type clientCaptureSummary struct {
+	captureSummary
+	SPKIHashes        []string `json:"spki_hashes"`
+	CertSubstituted   bool     `json:"cert_substituted"`
+	PinStatus         string   `json:"pin_status"`
+	DowngradeSentinel bool     `json:"downgrade_sentinel"`
+}
+
+type serverCaptureSummary struct {
+	captureSummary
+	ClientIP       net.IP                  `json:"client_ip"`
+	ServerIP       net.IP                  `json:"server_ip"`
+	TLS13Disabled  bool                    `json:"tls13_disabled"`
+	JA3String      string                  `json:"ja3_string"`
+	JA3Fingerprint string                  `json:"ja3_fingerprint"`
+	Fingerprint    *ClientHelloFingerprint `json:"fingerprint"`
+}
+
+// subtestWithCaptures is a subtest with its captures inlined.
+type subtestWithCaptures struct {
+	*Subtest
+	ClientCapture  *clientCaptureSummary   `json:"client_capture"`
+	ServerCaptures []*serverCaptureSummary `json:"server_captures"`
+}
+
+func summarizeCapture(capture *Capture) captureSummary {
+	return captureSummary{
+		CreatedAt:         capture.CreatedAt,
+		BeginTime:         capture.BeginTime,
+		EndTime:           capture.EndTime,
+		ActualTLSVersion:  capture.ActualTLSVersion,
+		HasFailed:         capture.HasFailed,
+		FrameCount:        len(capture.Frames),
+		CipherSuite:       capture.CipherSuite,
+		KeyExchangeGroup:  capture.KeyExchangeGroup,
+		ALPNProtocol:      capture.ALPNProtocol,
+		IsResumed:         capture.IsResumed,
+		HelloRetryRequest: capture.HelloRetryRequest,
+	}
+}
+
+func summarizeSubtestResult(result *SubtestResult) *subtestWithCaptures {
+	summary := &subtestWithCaptures{
+		Subtest:        result.Subtest,
+		ServerCaptures: []*serverCaptureSummary{},
+	}
+	if result.ClientCapture != nil {
+		summary.ClientCapture = &clientCaptureSummary{
+			captureSummary:    summarizeCapture(&result.ClientCapture.Capture),
+			SPKIHashes:        result.ClientCapture.SPKIHashes,
+			CertSubstituted:   result.ClientCapture.CertSubstituted,
+			PinStatus:         result.ClientCapture.PinStatus,
+			DowngradeSentinel: result.ClientCapture.DowngradeSentinel,
+		}
+	}
+	for _, capture := range result.ServerCaptures {
+		summary.ServerCaptures = append(summary.ServerCaptures, &serverCaptureSummary{
+			captureSummary: summarizeCapture(&capture.Capture),
+			ClientIP:       capture.ClientIP,
+			ServerIP:       capture.ServerIP,
+			TLS13Disabled:  capture.TLS13Disabled,
+			JA3String:      capture.JA3String,
+			JA3Fingerprint: capture.JA3Fingerprint,
+			Fingerprint:    capture.Fingerprint,
+		})
+	}
+	return summary
+}
+
+// includeCaptures returns true if captures should be inlined in the response.
+func includeCaptures(c *gin.Context) bool {
+	for _, include := range strings.Split(c.Query("include"), ",") {
+		if include == "captures" {
+			return true
+		}
+	}
+	return false
+}
+
+// getSubtests returns the subtests for the test given in the request, ordered
+// by number. Captures are only retrieved if requested.
+func (r *reporter) getSubtests(c *gin.Context, withCaptures bool) ([]*SubtestResult, bool) {
+	if withCaptures {
+		return r.getSubtestResults(c)
+	}
+
+	testIDKey, ok := r.getTestKey(c)
+	if !ok {
+		return nil, false
+	}
+	rows, err := QuerySubtests(r.db, `
+	WHERE subtests.test_id = $1
+	ORDER BY subtests.number
+	`, testIDKey)
+	if err != nil {
+		r.dbError(c, err)
+		return nil, false
+	}
+	defer rows.Close()
+	var results []*SubtestResult
+	for rows.Next() {
+		subtest, err := ScanSubtest(rows)
+		if err != nil {
+			r.dbError(c, err)
+			return nil, false
+		}
+		results = append(results, &SubtestResult{Subtest: subtest})
+	}
+	if err = rows.Err(); err != nil {
+		r.dbError(c, err)
+		return nil, false
+	}
+	return results, true
+}
+
+func subtestResponse(result *SubtestResult, withCaptures bool) interface{} {
+	if withCaptures {
+		return summarizeSubtestResult(result)
+	}
+	return result.Subtest
+}
+
+func (r *reporter) listSubtests(c *gin.Context) {
+	withCaptures := includeCaptures(c)
+	results, ok := r.getSubtests(c, withCaptures)
+	if !ok {
+		return
+	}
+	subtests := []interface{}{}
+	for _, result := range results {
+		subtests = append(subtests, subtestResponse(result, withCaptures))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"result": subtests,
+	})
+}
+
+func (r *reporter) listSubtest(c *gin.Context) {
+	subtestNumber, ok := r.getSubtestNumber(c)
+	if !ok {
+		return
+	}
+	withCaptures := includeCaptures(c)
+	results, ok := r.getSubtests(c, withCaptures)
+	if !ok {
+		return
+	}
+	for _, result := range results {
+		if result.Number == subtestNumber {
+			c.JSON(http.StatusOK, subtestResponse(result, withCaptures))
+			return
+		}
+	}
+	c.JSON(http.StatusNotFound, errSubTestNotFound)
+}
+
+// hashDeletionToken returns the hex-encoded SHA256 hash of a deletion token.
+func hashDeletionToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// allowDeletionToken removes the test if the request presents a deletion token
+// (without requiring an API key). Requests without a token are left to the
+// remaining handlers.
+func (r *reporter) allowDeletionToken(c *gin.Context) {
+	token := c.GetHeader("X-Deletion-Token")
+	if token == "" {
+		return
+	}
+	c.Abort()
+
+	testID, ok := r.getTestID(c)
+	if !ok {
+		return
+	}
+	var storedHash string
+	err := r.db.QueryRow(`
+	SELECT deletion_token_hash FROM tests WHERE test_id = $1
+	`, testID).Scan(&storedHash)
+	switch {
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, errTestNotFound)
+		return
+	case err != nil:
+		r.dbError(c, err)
+		return
+	}
+	// tests without a stored hash never match.
+	storedHashBytes, _ := hex.DecodeString(storedHash)
+	tokenHash := sha256.Sum256([]byte(token))
+	if subtle.ConstantTimeCompare(storedHashBytes, tokenHash[:]) != 1 {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "invalid deletion token",
+		})
+		return
+	}
+	c.Set(apiKeyNameKey, deletionTokenKeyName)
+	r.removeTest(c)
+}
+
 func (r *reporter) removeTest(c *gin.Context) {
 	testID, ok := r.getTestID(c)
 	if !ok {
@@ -495,3 +988,53 @@ func (r *reporter) removeTest(c *gin.Context) {
 
 	c.JSON(http.StatusNotFound, errTestNotFound)
 }
+
+func (r *reporter) getClientPcap(c *gin.Context) {
+	results, ok := r.getSubtestResults(c)
+	if !ok {
+		return
+	}
+	r.writePcap(c, buildClientPackets(results))
+}
+
+func (r *reporter) getServerPcap(c *gin.Context) {
+	results, ok := r.getSubtestResults(c)
+	if !ok {
+		return
+	}
+	r.writePcap(c, buildServerPackets(results))
+}
+
+func (r *reporter) getPcapng(c *gin.Context) {
+	results, ok := r.getSubtestResults(c)
+	if !ok {
+		return
+	}
+	var buffer bytes.Buffer
+	if err := writePcapng(&buffer, results); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.Data(http.StatusOK, "application/x-pcapng", buffer.Bytes())
+}
+
+func (r *reporter) getKeyLog(c *gin.Context) {
+	results, ok := r.getSubtestResults(c)
+	if !ok {
+		return
+	}
+	keyLog, mismatches := mergeKeyLogs(results)
+	if len(mismatches) > 0 {
+		c.Header("X-Key-Log-Mismatches", strconv.Itoa(len(mismatches)))
+	}
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", keyLog)
+}
+
+func (*reporter) writePcap(c *gin.Context, packets []pcapPacket) {
+	var buffer bytes.Buffer
+	if err := writePcap(&buffer, packets); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.Data(http.StatusOK, "application/vnd.tcpdump.pcap", buffer.Bytes())
+}
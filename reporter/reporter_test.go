@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAuthRequired(t *testing.T) {
+	hashKey := func(key string) string {
+		hash := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(hash[:])
+	}
+	keys := []ApiKey{
+		{Name: "alice", Hash: hashKey("reader-secret"), Role: roleRead},
+		{Name: "bob", Hash: hashKey("writer-secret"), Role: roleWrite},
+		{Name: "invalid-hash", Hash: "abcd", Role: roleWrite},
+		{Name: "invalid-role", Hash: hashKey("other-secret"), Role: "admin"},
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handler := func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString(apiKeyNameKey))
+	}
+	router.GET("/read", makeAuthRequired(keys, roleRead), handler)
+	router.GET("/write", makeAuthRequired(keys, roleWrite), handler)
+
+	for _, test := range []struct {
+		path, secret string
+		expectedName string
+	}{
+		{"/read", "reader-secret", "alice"},
+		{"/read", "writer-secret", "bob"},
+		{"/write", "writer-secret", "bob"},
+		{"/write", "reader-secret", ""},
+		{"/read", "", ""},
+		{"/read", "wrong-secret", ""},
+		{"/write", "other-secret", ""},
+	} {
+		req := httptest.NewRequest("GET", test.path, nil)
+		if test.secret != "" {
+			req.Header.Set("X-API-Secret", test.secret)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if test.expectedName == "" {
+			if w.Code != http.StatusForbidden {
+				t.Errorf("%s with %q: expected 403, got %d", test.path, test.secret, w.Code)
+			}
+		} else if w.Code != http.StatusOK || w.Body.String() != test.expectedName {
+			t.Errorf("%s with %q: expected key %q, got %d %q", test.path, test.secret,
+				test.expectedName, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestAllowDeletionToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rep := &reporter{}
+	router := gin.New()
+	router.DELETE("/tests/:testid", rep.allowDeletionToken, func(c *gin.Context) {
+		c.Status(http.StatusTeapot)
+	})
+
+	// requests without a token are handled by the next handler.
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/tests/x", nil))
+	if w.Code != http.StatusTeapot {
+		t.Errorf("expected fall through, got %d", w.Code)
+	}
+
+	// requests with a token for an invalid test are rejected.
+	req := httptest.NewRequest("DELETE", "/tests/x", nil)
+	req.Header.Set("X-Deletion-Token", GenerateToken())
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for invalid test, got %d", w.Code)
+	}
+}
@@ -0,0 +1,153 @@
+// Data retention. Frames and key logs are sensitive and are removed after a
+// configurable period, the remaining test results can be removed later.
+package main
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+)
+
+// execer is implemented by *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// purgedColumn is a sensitive capture column and the value that replaces it.
+type purgedColumn struct {
+	name  string
+	empty string
+}
+
+// Sensitive columns of the capture tables. The ClientHello received by the
+// server includes the client random and session ID.
+var purgedCaptureColumns = map[string][]purgedColumn{
+	"client_captures": {
+		{"frames", "'[]'"},
+		{"key_log", "''"},
+	},
+	"server_captures": {
+		{"frames", "'[]'"},
+		{"key_log", "''"},
+		{"client_hello", "'null'"},
+		{"fingerprint", "'null'"},
+	},
+}
+
+// purgeCaptureData clears the frames, key logs and other sensitive data of
+// captures in the given table that are older than maxAgeSecs. Captures of
+// pending tests are kept as their verdicts are not computed yet. Rows are
+// updated in batches to avoid long-running transactions. Returns the number of
+// purged captures.
+func purgeCaptureData(execer execer, table string, maxAgeSecs, batchSize int) (int64, error) {
+	var assignments, conditions []string
+	for _, column := range purgedCaptureColumns[table] {
+		assignments = append(assignments, column.name+" = "+column.empty)
+		conditions = append(conditions, table+"."+column.name+" <> "+column.empty)
+	}
+	var total int64
+	for {
+		result, err := execer.Exec(`
+		UPDATE `+table+`
+		SET
+			`+strings.Join(assignments, ",\n\t\t\t")+`
+		WHERE id IN (
+			SELECT `+table+`.id FROM `+table+`
+			JOIN subtests
+			ON subtests.id = `+table+`.subtest_id
+			JOIN tests
+			ON tests.id = subtests.test_id
+			WHERE
+				NOT tests.is_pending AND
+				now() - `+table+`.created_at >= $1 AND
+				(`+strings.Join(conditions, " OR ")+`)
+			LIMIT $2
+		)
+		`, maxAgeSecs, batchSize)
+		if err != nil {
+			return total, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
+// purgeTests removes concluded tests (including subtests and captures) that
+// are older than maxAgeSecs in batches. Returns the number of removed tests.
+func purgeTests(execer execer, maxAgeSecs, batchSize int) (int64, error) {
+	var total int64
+	for {
+		result, err := execer.Exec(`
+		DELETE FROM tests
+		WHERE id IN (
+			SELECT id FROM tests
+			WHERE
+				NOT is_pending AND
+				now() - created_at >= $1
+			LIMIT $2
+		)
+		`, maxAgeSecs, batchSize)
+		if err != nil {
+			return total, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
+// purgeExpiredData applies the retention policy once.
+func purgeExpiredData(db *sql.DB, config *Config) {
+	batchSize := config.RetentionBatchSize
+	if maxAge := config.TestRetentionSecs; maxAge > 0 {
+		n, err := purgeTests(db, maxAge, batchSize)
+		if err != nil {
+			log.Printf("Retention: failed to remove tests: %s", err)
+		} else if n > 0 {
+			log.Printf("Retention: removed %d tests", n)
+		}
+	}
+	if maxAge := config.CaptureDataRetentionSecs; maxAge > 0 {
+		for _, table := range []string{"client_captures", "server_captures"} {
+			n, err := purgeCaptureData(db, table, maxAge, batchSize)
+			if err != nil {
+				log.Printf("Retention: failed to purge %s: %s", table, err)
+			} else if n > 0 {
+				log.Printf("Retention: purged frames and key logs of %d %s", n, table)
+			}
+		}
+	}
+}
+
+// startRetention periodically removes expired data in the background if a
+// retention period is configured.
+func startRetention(db *sql.DB, config *Config) {
+	if config.CaptureDataRetentionSecs <= 0 && config.TestRetentionSecs <= 0 {
+		return
+	}
+	if config.RetentionBatchSize <= 0 {
+		log.Println("Configured RetentionBatchSize is invalid, disabling retention.")
+		return
+	}
+	// captures are needed until tests are concluded.
+	if config.CaptureDataRetentionSecs > 0 && config.CaptureDataRetentionSecs <= config.MutableTestPeriodSecs {
+		log.Println("Configured CaptureDataRetentionSecs must exceed MutableTestPeriodSecs, disabling retention.")
+		return
+	}
+	interval := time.Duration(config.RetentionIntervalSecs) * time.Second
+	go runPeriodically(interval, func() {
+		purgeExpiredData(db, config)
+	})
+}
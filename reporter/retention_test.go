@@ -0,0 +1,162 @@
+package main
+
+import (
+	"database/sql"
+	"net"
+	"testing"
+)
+
+// createRetentionTest creates a test with one subtest and a client capture per
+// entry of ages (in seconds). Returns the internal TestID and capture IDs.
+func createRetentionTest(t *testing.T, tx *sql.Tx, isPending bool, ages []int) (int, []int) {
+	m := &Test{
+		ClientIP:  net.ParseIP("::"),
+		IsPending: isPending,
+	}
+	if err := m.Create(tx); err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	var captureIDs []int
+	for i, age := range ages {
+		subtest := &Subtest{
+			TestID: m.ID,
+			Number: i + 1,
+		}
+		if err := subtest.Create(tx); err != nil {
+			t.Fatalf("Subtest query failed: %v", err)
+		}
+		clientCapture := &ClientCapture{Capture: Capture{
+			SubtestID: subtest.ID,
+			Frames:    []Frame{{IsRead: true, Data: []byte{22}}},
+			KeyLog:    "CLIENT_RANDOM 00 00\n",
+		}}
+		if err := clientCapture.Create(tx); err != nil {
+			t.Fatalf("Client capture query failed: %v", err)
+		}
+		_, err := tx.Exec(`
+		UPDATE client_captures
+		SET created_at = now() - $2 * interval '1 second'
+		WHERE id = $1
+		`, clientCapture.ID, age)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		captureIDs = append(captureIDs, clientCapture.ID)
+	}
+	return m.ID, captureIDs
+}
+
+func TestPurgeCaptureData(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration tests")
+		return
+	}
+	db := testDB()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Transaction start failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, concluded := createRetentionTest(t, tx, false, []int{7200, 7200, 7200, 60})
+	_, pending := createRetentionTest(t, tx, true, []int{7200})
+
+	// a batch size of one requires multiple batches.
+	n, err := purgeCaptureData(tx, "client_captures", 3600, 1)
+	if err != nil {
+		t.Fatalf("purgeCaptureData failed: %v", err)
+	}
+	if n < 3 {
+		t.Errorf("expected at least 3 purged captures, got %d", n)
+	}
+
+	isPurged := func(captureID int) bool {
+		var purged bool
+		err := tx.QueryRow(`
+		SELECT frames = '[]' AND key_log = ''
+		FROM client_captures
+		WHERE id = $1
+		`, captureID).Scan(&purged)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		return purged
+	}
+	for i, captureID := range concluded[:3] {
+		if !isPurged(captureID) {
+			t.Errorf("expected expired capture %d to be purged", i)
+		}
+	}
+	if isPurged(concluded[3]) {
+		t.Error("recent capture must not be purged")
+	}
+	if isPurged(pending[0]) {
+		t.Error("capture of pending test must not be purged")
+	}
+
+	// server captures have further sensitive columns.
+	if _, err = purgeCaptureData(tx, "server_captures", 3600, 1); err != nil {
+		t.Errorf("purgeCaptureData failed for server captures: %v", err)
+	}
+}
+
+func TestPurgeTests(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration tests")
+		return
+	}
+	db := testDB()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Transaction start failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	var expired, recent, pending []int
+	for i := 0; i < 3; i++ {
+		testID, _ := createRetentionTest(t, tx, false, nil)
+		expired = append(expired, testID)
+	}
+	testID, _ := createRetentionTest(t, tx, false, nil)
+	recent = append(recent, testID)
+	testID, _ = createRetentionTest(t, tx, true, nil)
+	pending = append(pending, testID)
+	for _, testID := range append(expired, pending...) {
+		_, err = tx.Exec(`
+		UPDATE tests
+		SET created_at = now() - interval '2 hours'
+		WHERE id = $1
+		`, testID)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+	}
+
+	n, err := purgeTests(tx, 3600, 2)
+	if err != nil {
+		t.Fatalf("purgeTests failed: %v", err)
+	}
+	if n < 3 {
+		t.Errorf("expected at least 3 removed tests, got %d", n)
+	}
+
+	exists := func(testID int) bool {
+		var count int
+		err := tx.QueryRow(`SELECT count(*) FROM tests WHERE id = $1`, testID).Scan(&count)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		return count != 0
+	}
+	for i, testID := range expired {
+		if exists(testID) {
+			t.Errorf("expected expired test %d to be removed", i)
+		}
+	}
+	if !exists(recent[0]) {
+		t.Error("recent test must not be removed")
+	}
+	if !exists(pending[0]) {
+		t.Error("pending test must not be removed")
+	}
+}
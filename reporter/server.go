@@ -28,30 +28,74 @@ func (h *hostHandler) getCertificate(info *tls.ClientHelloInfo) (*tls.Certificat
 	return h.dummyCert.Load()
 }
 
-// Sets the maximum version for the test server target to TLS 1.3.
+// Sets the maximum version for the test server target to TLS 1.3, unless the
+// subtest expects a TLS 1.3 version that is not offered by the client.
 func (h *hostHandler) getConfigForClient(info *tls.ClientHelloInfo) (*tls.Config, error) {
 	if isTestHost(info.ServerName, h.config) {
+		baseConfig := h.tls13Config
+		tls13Disabled := !acceptsTLS13(h.config, info.ServerName, info.SupportedVersions)
+		if tls13Disabled {
+			baseConfig = h.tls12Config
+		}
 		if c, ok := info.Conn.(*serverCaptureConn); ok {
+			c.info.TLS13Disabled = tls13Disabled
 			// clone the TLS configuration in order to pass some
 			// context to the keylog callback.
-			tlsConfig := h.tls13Config.Clone()
+			tlsConfig := baseConfig.Clone()
 			tlsConfig.KeyLogWriter = serverKeyLog{&c.info.KeyLog, tlsConfig.KeyLogWriter}
 			// Note: as a side-effect, this disables HTTP/2 support
 			// (as desired, our tests use plain HTTP). Normally
 			// http.Server.ServeTLS enables TLS, but since we have
 			// cloned TLSConfig before that, it remains disabled in
-			// the cloned tls13Config.
+			// the cloned tls12Config and tls13Config.
 			return tlsConfig, nil
 		}
-		return h.tls13Config, nil
+		return baseConfig, nil
 	}
 	return nil, nil
 }
 
+// isTLS13Version returns true for the final TLS 1.3 version and its drafts.
+func isTLS13Version(version uint16) bool {
+	return version == tls.VersionTLS13 || version>>8 == 0x7f
+}
+
+// acceptsTLS13 returns false if the subtest of the test host explicitly expects
+// a TLS 1.3 version and the client offers other TLS 1.3 versions, but not the
+// expected one. Negotiating another TLS 1.3 version would not test the version
+// that was asked for.
+//
+// Note: this cannot pin the negotiated version. If the client offers multiple
+// TLS 1.3 versions, the TLS library selects one of those that it implements.
+// A ClientHello without any TLS 1.3 version is accepted, such that the
+// downgrade sentinel is sent as usual.
+func acceptsTLS13(config *Config, host string, supportedVersions []uint16) bool {
+	_, number := parseTestHost(config, strings.ToLower(host))
+	for _, spec := range config.Subtests {
+		if spec.Number != number {
+			continue
+		}
+		if !isTLS13Version(spec.ExpectedVersion) {
+			// no TLS 1.3 version was requested.
+			break
+		}
+		offersTLS13 := false
+		for _, version := range supportedVersions {
+			if version == spec.ExpectedVersion {
+				return true
+			}
+			offersTLS13 = offersTLS13 || isTLS13Version(version)
+		}
+		return !offersTLS13
+	}
+	return true
+}
+
 type hostHandler struct {
 	http.Handler
 	reporterHandler http.Handler
 	config          *Config
+	tls12Config     *tls.Config
 	tls13Config     *tls.Config
 	reporterCert    *CertificateLoader
 	dummyCert       *CertificateLoader
@@ -61,7 +105,7 @@ func isTestHost(host string, config *Config) bool {
 	return strings.HasSuffix(host, config.HostSuffixIPv4) || strings.HasSuffix(host, config.HostSuffixIPv6)
 }
 
-func makeIsOurHost(db *sql.DB, config *Config) RequestClaimer {
+func makeIsOurHost(db *sql.DB, config *Config, ephemeralTests *ephemeralStore) RequestClaimer {
 	return func(host string) (bool, int) {
 		host = strings.ToLower(host)
 		if host == config.HostReporter {
@@ -71,18 +115,25 @@ func makeIsOurHost(db *sql.DB, config *Config) RequestClaimer {
 		if isTestHost(host, config) {
 			// pass to HTTP handler, handling a basic response.
 			// Logging is tentatively enabled.
-			return true, prepareServerCapture(db, config, host)
+			return true, prepareServerCapture(db, config, ephemeralTests, host)
 		}
 		return false, 0
 	}
 }
 
-func prepareServerCapture(db *sql.DB, config *Config, host string) int {
+func prepareServerCapture(db *sql.DB, config *Config, ephemeralTests *ephemeralStore, host string) int {
 	testID, number := parseTestHost(config, host)
 	if testID == "" {
 		log.Printf("Host \"%s\" is not a valid test domain, ignoring", host)
 		return 0
 	}
+	if isEphemeralTestID(testID) {
+		if !ephemeralTests.BeginServerCapture(testID, number) {
+			log.Printf("Not accepting server capture for \"%s\"", host)
+			return 0
+		}
+		return ephemeralSubtestID
+	}
 	subtestID, err := QuerySubtest(db, testID, number, config.MutableTestPeriodSecs)
 	if err != nil {
 		log.Printf("Failed to query subtest for \"%s\": %s", host, err)
@@ -96,7 +147,8 @@ func prepareServerCapture(db *sql.DB, config *Config, host string) int {
 }
 
 // parses a host name of the form "<testID>-<number><suffix>", returning the
-// TestID and subtest number. On error, the testID is empty.
+// TestID and subtest number. On error, the testID is empty. The TestID of
+// anonymous tests is prefixed with "otr-".
 func parseTestHost(config *Config, host string) (string, int) {
 	var prefix string
 	switch {
@@ -109,11 +161,15 @@ func parseTestHost(config *Config, host string) (string, int) {
 	}
 
 	// testID UUID is always 36 chars followed by "-" and number.
-	if len(prefix) < 36+2 || prefix[36] != '-' {
+	idLength := 36
+	if strings.HasPrefix(prefix, ephemeralTestPrefix) {
+		idLength += len(ephemeralTestPrefix)
+	}
+	if len(prefix) < idLength+2 || prefix[idLength] != '-' {
 		return "", 0
 	}
-	testID, numberStr := prefix[0:36], prefix[37:]
-	if !ValidateUUID(testID) {
+	testID, numberStr := prefix[0:idLength], prefix[idLength+1:]
+	if !ValidateUUID(testID[idLength-36:]) {
 		return "", 0
 	}
 	number, err := strconv.Atoi(numberStr)
@@ -124,9 +180,18 @@ func parseTestHost(config *Config, host string) (string, int) {
 	return testID, number
 }
 
-func newServerCaptureReady(db *sql.DB) ServerCaptureNotifier {
+func newServerCaptureReady(db *sql.DB, config *Config, ephemeralTests *ephemeralStore, finalizers []TestFinalizer) ServerCaptureNotifier {
 	return func(name string, serverCapture *ServerCapture) {
-		err := serverCapture.Create(db)
+		// captures of anonymous tests are only kept in memory.
+		if serverCapture.SubtestID == ephemeralSubtestID {
+			testID, number := parseTestHost(config, name)
+			if !ephemeralTests.AddServerCapture(testID, number, serverCapture) {
+				log.Printf("Discarded server capture for %s", name)
+			}
+			return
+		}
+
+		err := StoreServerCapture(db, serverCapture, finalizers)
 		if err != nil {
 			log.Printf("Failed to create server capture: %s", err)
 			return
@@ -168,15 +233,15 @@ func (h *hostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		defer conn.Close()
 
-		// try to log results for non-anonymous requests.
+		// try to log results for valid test hosts.
 		testID, _ := parseTestHost(h.config, sni)
 		if tlsConn, ok := conn.(*tls.Conn); ok && testID != "" {
 			serverConn, err := serverCaptureConnFromTLSConn(tlsConn)
 			if err != nil {
 				// note: this could also fire for invalid UUID
-				log.Printf("Failed to set version: %s", err)
+				log.Printf("Failed to set connection state: %s", err)
 			} else {
-				serverConn.SetActualTLSVersion(r.TLS.Version)
+				serverConn.SetConnectionState(r.TLS)
 			}
 		}
 
@@ -275,6 +340,7 @@ func main() {
 	if err = db.Ping(); err != nil {
 		panic(err)
 	}
+	startRetention(db, config)
 
 	if config.FlashListenAddress != "" {
 		fl, err := net.Listen("tcp", config.FlashListenAddress)
@@ -289,12 +355,27 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	ephemeralTests := newEphemeralStore(time.Duration(config.EphemeralTestTTLSecs) * time.Second)
+
+	var middleboxes *middleboxDatabase
+	if config.MiddleboxFingerprintsFile != "" {
+		middleboxes, err = loadMiddleboxDatabase(config.MiddleboxFingerprintsFile)
+		if err != nil {
+			log.Fatalf("Failed to load middlebox fingerprints: %s", err)
+		}
+	}
+
+	rep := newReporter(db, config, ephemeralTests, middleboxes, dummyCert)
+	rep.startPendingTestExpiry()
+
 	initialReadTimeout := time.Duration(config.InitialReadTimeoutSecs) * time.Second
-	wl := newListener(l, initialReadTimeout, config.OriginAddress, makeIsOurHost(db, config), newServerCaptureReady(db), flashPolicyServer)
+	wl := newListener(l, initialReadTimeout, config.OriginAddress,
+		makeIsOurHost(db, config, ephemeralTests),
+		newServerCaptureReady(db, config, ephemeralTests, rep.testFinalizers), flashPolicyServer)
 	go wl.Serve()
 
 	hostRouter := &hostHandler{
-		reporterHandler: newReporter(db, config),
+		reporterHandler: rep,
 		config:          config,
 		reporterCert:    reporterCert,
 		dummyCert:       dummyCert,
@@ -318,6 +399,8 @@ func main() {
 
 	hostRouter.tls13Config = tlsConfig.Clone()
 	hostRouter.tls13Config.MaxVersion = tls.VersionTLS13
+	hostRouter.tls12Config = tlsConfig.Clone()
+	hostRouter.tls12Config.MaxVersion = tls.VersionTLS12
 
 	server := &http.Server{
 		Handler:      hostRouter,
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"testing"
+)
+
+func TestAcceptsTLS13(t *testing.T) {
+	config := defaultConfig
+	config.Subtests = []SubtestSpec{
+		{Number: 1, MaxTLSVersion: tls.VersionTLS12},
+		{Number: 2, MaxTLSVersion: tls.VersionTLS13, ExpectedVersion: tls.VersionTLS13},
+		{Number: 3, MaxTLSVersion: tls.VersionTLS13, ExpectedVersion: 0x7f16},
+		{Number: 4, MaxTLSVersion: tls.VersionTLS13, ExpectedVersion: 0x7f17},
+		{Number: 6, MaxTLSVersion: tls.VersionTLS13},
+	}
+	host := func(number int) string {
+		return fmt.Sprintf("6b5742d9-722b-4d12-848a-c42da771b806-%d%s", number, config.HostSuffixIPv4)
+	}
+	draft22 := []uint16{0x7f16, tls.VersionTLS12}
+	final := []uint16{tls.VersionTLS13, tls.VersionTLS12}
+	tls12 := []uint16{tls.VersionTLS12}
+	for _, test := range []struct {
+		name              string
+		host              string
+		supportedVersions []uint16
+		accepts           bool
+	}{
+		{"TLS 1.2 subtest", host(1), draft22, true},
+		{"final version", host(2), final, true},
+		{"final version not offered", host(2), draft22, false},
+		{"expected draft", host(3), draft22, true},
+		{"expected draft not offered", host(3), final, false},
+		{"other draft", host(4), draft22, false},
+		{"no TLS 1.3 version offered", host(4), tls12, true},
+		{"unknown subtest", host(5), nil, true},
+		{"no expected version", host(6), final, true},
+		{"upper case host", "6B5742D9-722B-4D12-848A-C42DA771B806-3" + config.HostSuffixIPv4, draft22, true},
+	} {
+		if accepts := acceptsTLS13(&config, test.host, test.supportedVersions); accepts != test.accepts {
+			t.Errorf("%s: expected %t, got %t", test.name, test.accepts, accepts)
+		}
+	}
+}
+
+func TestExpectedTLSVersion(t *testing.T) {
+	for _, test := range []struct {
+		spec     SubtestSpec
+		expected uint16
+	}{
+		{SubtestSpec{MaxTLSVersion: tls.VersionTLS12}, tls.VersionTLS12},
+		{SubtestSpec{MaxTLSVersion: tls.VersionTLS13}, versionTLS13Draft22},
+		{SubtestSpec{MaxTLSVersion: tls.VersionTLS13, ExpectedVersion: tls.VersionTLS13}, tls.VersionTLS13},
+		{SubtestSpec{MaxTLSVersion: tls.VersionTLS13, ExpectedVersion: 0x7f17}, 0x7f17},
+	} {
+		if version := test.spec.expectedTLSVersion(); version != test.expected {
+			t.Errorf("%+v: expected %#04x, got %#04x", test.spec, test.expected, version)
+		}
+	}
+}
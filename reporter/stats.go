@@ -0,0 +1,179 @@
+// Summary statistics for analysts.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Time range that is used when no range is given.
+const defaultStatsPeriod = 30 * 24 * time.Hour
+
+// statsBucket contains the subtest counts for one day and configuration.
+type statsBucket struct {
+	Day           time.Time `json:"day"`
+	MaxTLSVersion uint16    `json:"max_tls_version"`
+	IsIPv6        bool      `json:"is_ipv6"`
+	ClientVersion string    `json:"client_version"`
+	// Distinguishes subtests for different TLS 1.3 drafts.
+	ExpectedVersion uint16 `json:"expected_version"`
+	// Number of reports that contributed to this bucket.
+	Reports int `json:"reports"`
+	// Number of subtests, split by outcome. Subtests without a client
+	// result are only counted as NoClientResult.
+	Subtests           int `json:"subtests"`
+	NoClientResult     int `json:"no_client_result"`
+	HandshakeFailed    int `json:"handshake_failed"`
+	HandshakeSucceeded int `json:"handshake_succeeded"`
+	MitmDowngraded     int `json:"mitm_downgraded"`
+	MitmOther          int `json:"mitm_other"`
+	Ok                 int `json:"ok"`
+}
+
+// tlsVersionRankSQL returns a SQL expression that orders TLS versions by the
+// protocol version. TLS 1.3 drafts (0x7fXX) rank as the final TLS 1.3 version,
+// although their codepoints are greater.
+func tlsVersionRankSQL(column string) string {
+	// 0x7f00 - 0x7fff map to 0x0304.
+	return fmt.Sprintf("(CASE WHEN %[1]s BETWEEN 32512 AND 32767 THEN 772 ELSE %[1]s END)", column)
+}
+
+// parseStatsRange returns the [from, to) time range from the parameters.
+func parseStatsRange(fromValue, toValue string, now time.Time) (time.Time, time.Time, error) {
+	to := now.UTC()
+	if toValue != "" {
+		t, err := time.Parse(time.RFC3339, toValue)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("to must be a RFC 3339 time")
+		}
+		to = t.UTC()
+	}
+	from := to.Add(-defaultStatsPeriod)
+	if fromValue != "" {
+		t, err := time.Parse(time.RFC3339, fromValue)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("from must be a RFC 3339 time")
+		}
+		from = t.UTC()
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, errors.New("from must be before to")
+	}
+	return from, to, nil
+}
+
+func (r *reporter) getStats(c *gin.Context) {
+	from, to, err := parseStatsRange(c.Query("from"), c.Query("to"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// only concluded tests have a verdict.
+	var reports, pendingReports int
+	err = r.db.QueryRow(`
+	SELECT
+		count(*) FILTER (WHERE NOT is_pending),
+		count(*) FILTER (WHERE is_pending)
+	FROM tests
+	WHERE
+		created_at >= $1 AND
+		created_at < $2
+	`, from, to).Scan(&reports, &pendingReports)
+	if err != nil {
+		r.dbError(c, err)
+		return
+	}
+
+	buckets, err := queryStats(r.db, from, to)
+	if err != nil {
+		r.dbError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":            from,
+		"to":              to,
+		"reports":         reports,
+		"pending_reports": pendingReports,
+		"result":          buckets,
+	})
+}
+
+// queryStats returns the subtest counts of concluded tests that were created
+// within the [from, to) time range, bucketed by day and configuration.
+func queryStats(querier Querier, from, to time.Time) ([]*statsBucket, error) {
+	// a lower version than expected was negotiated.
+	actualRank := tlsVersionRankSQL("client_captures.actual_tls_version")
+	expectedRank := tlsVersionRankSQL("subtests.expected_version")
+	rows, err := querier.Query(`
+	SELECT
+		date_trunc('day', tests.created_at),
+		subtests.max_tls_version,
+		subtests.is_ipv6,
+		tests.client_version,
+		subtests.expected_version,
+		count(DISTINCT tests.id),
+		count(*),
+		count(*) FILTER (WHERE subtests.no_client_result),
+		count(*) FILTER (WHERE subtests.has_failed AND NOT subtests.no_client_result),
+		count(*) FILTER (WHERE NOT subtests.has_failed AND NOT subtests.no_client_result),
+		count(*) FILTER (WHERE NOT subtests.has_failed AND NOT subtests.no_client_result AND
+			subtests.is_mitm AND
+			`+actualRank+` < `+expectedRank+`),
+		count(*) FILTER (WHERE NOT subtests.has_failed AND NOT subtests.no_client_result AND
+			subtests.is_mitm AND
+			NOT COALESCE(`+actualRank+` < `+expectedRank+`, FALSE)),
+		count(*) FILTER (WHERE NOT subtests.has_failed AND NOT subtests.no_client_result AND
+			NOT subtests.is_mitm)
+	FROM subtests
+	JOIN tests
+	ON subtests.test_id = tests.id
+	LEFT JOIN client_captures
+	ON subtests.id = client_captures.subtest_id
+	WHERE
+		NOT tests.is_pending AND
+		tests.created_at >= $1 AND
+		tests.created_at < $2
+	GROUP BY 1, 2, 3, 4, 5
+	ORDER BY 1, 2, 3, 4, 5
+	`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := []*statsBucket{}
+	for rows.Next() {
+		bucket := new(statsBucket)
+		err = rows.Scan(
+			&bucket.Day,
+			&bucket.MaxTLSVersion,
+			&bucket.IsIPv6,
+			&bucket.ClientVersion,
+			&bucket.ExpectedVersion,
+			&bucket.Reports,
+			&bucket.Subtests,
+			&bucket.NoClientResult,
+			&bucket.HandshakeFailed,
+			&bucket.HandshakeSucceeded,
+			&bucket.MitmDowngraded,
+			&bucket.MitmOther,
+			&bucket.Ok,
+		)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, bucket)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return buckets, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseStatsRange(t *testing.T) {
+	now := parseTime("2017-12-07T23:40:36Z")
+	from, to, err := parseStatsRange("", "", now)
+	if err != nil || !to.Equal(now) || to.Sub(from) != defaultStatsPeriod {
+		t.Errorf("unexpected default range %v - %v (%v)", from, to, err)
+	}
+
+	from, to, err = parseStatsRange("2017-12-01T00:00:00+01:00", "2017-12-02T00:00:00Z", now)
+	if err != nil || from.Location() != time.UTC ||
+		!from.Equal(parseTime("2017-11-30T23:00:00Z")) || !to.Equal(parseTime("2017-12-02T00:00:00Z")) {
+		t.Errorf("unexpected range %v - %v (%v)", from, to, err)
+	}
+
+	for _, params := range [][2]string{
+		{"yesterday", ""},
+		{"", "tomorrow"},
+		{"2017-12-02T00:00:00Z", "2017-12-01T00:00:00Z"},
+	} {
+		if _, _, err := parseStatsRange(params[0], params[1], now); err == nil {
+			t.Errorf("expected error for %v", params)
+		}
+	}
+}
+
+func TestQueryStats(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration tests")
+		return
+	}
+	db := testDB()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Transaction start failed: %v", err)
+	}
+	defer tx.Rollback()
+
+	// distinguishes the buckets of this test from existing data.
+	clientVersion := "stats-test"
+	m := &Test{
+		ClientIP:      net.ParseIP("::"),
+		ClientVersion: clientVersion,
+		IsPending:     true,
+	}
+	if err = m.Create(tx); err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	// all handshakes succeeded without server capture, such that all
+	// subtests are considered intercepted.
+	for i, versions := range [][2]uint16{
+		// expected and negotiated version.
+		{versionTLS13Draft22, 0x0304},
+		{versionTLS13Draft22, 0x0303},
+		{0x0304, versionTLS13Draft22},
+	} {
+		subtest := &Subtest{
+			TestID:          m.ID,
+			Number:          i + 1,
+			MaxTLSVersion:   0x0304,
+			ExpectedVersion: versions[0],
+		}
+		if err = subtest.Create(tx); err != nil {
+			t.Fatalf("Subtest query failed: %v", err)
+		}
+		clientCapture := &ClientCapture{Capture: Capture{
+			SubtestID:        subtest.ID,
+			Frames:           []Frame{},
+			ActualTLSVersion: versions[1],
+		}}
+		if err = clientCapture.Create(tx); err != nil {
+			t.Fatalf("Client capture query failed: %v", err)
+		}
+	}
+	if err = FinalizeTest(tx, m.ID, defaultTestFinalizers); err != nil {
+		t.Fatalf("FinalizeTest failed: %v", err)
+	}
+
+	now := time.Now()
+	buckets, err := queryStats(tx, now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("queryStats failed: %v", err)
+	}
+	// TLS 1.3 drafts are no lower version than the final TLS 1.3.
+	expected := map[uint16]statsBucket{
+		versionTLS13Draft22: {Subtests: 2, HandshakeSucceeded: 2, MitmDowngraded: 1, MitmOther: 1},
+		0x0304:              {Subtests: 1, HandshakeSucceeded: 1, MitmOther: 1},
+	}
+	found := 0
+	for _, bucket := range buckets {
+		if bucket.ClientVersion != clientVersion {
+			continue
+		}
+		found++
+		want, ok := expected[bucket.ExpectedVersion]
+		if !ok {
+			t.Errorf("unexpected bucket %+v", bucket)
+			continue
+		}
+		if bucket.Reports != 1 || bucket.Subtests != want.Subtests ||
+			bucket.HandshakeSucceeded != want.HandshakeSucceeded ||
+			bucket.MitmDowngraded != want.MitmDowngraded ||
+			bucket.MitmOther != want.MitmOther || bucket.Ok != 0 {
+			t.Errorf("expected %+v, got %+v", want, bucket)
+		}
+	}
+	if found != len(expected) {
+		t.Errorf("expected %d buckets, got %d", len(expected), found)
+	}
+}
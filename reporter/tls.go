@@ -8,16 +8,14 @@ import (
 
 // TLS protocol constants
 const (
-	recordTypeHandshake uint8  = 22
-	typeClientHello     uint8  = 1
-	extensionServerName uint16 = 0
-	sniTypeHostname     uint8  = 0
+	sniTypeHostname uint8 = 0
 )
 
-// parseClientHello tries to parse a TLS record and extract the SNI.
-// Returns the parsed SNI and whether the message looks like TLS (even if no SNI
-// Extension is present, the message might still appear to be TLS).
-func parseClientHello(record []byte) (string, bool) {
+// parseClientHello tries to parse a TLS record containing a ClientHello.
+// Returns the parsed message (nil on failure) and whether the message looks
+// like TLS (even if no SNI Extension is present, the message might still appear
+// to be TLS).
+func parseClientHello(record []byte) (*ClientHello, bool) {
 	input := cryptobyte.String(record)
 
 	// parse record, but skip version
@@ -26,67 +24,230 @@ func parseClientHello(record []byte) (string, bool) {
 	if !input.ReadUint8(&contentType) ||
 		contentType != recordTypeHandshake ||
 		!input.Skip(2) || !input.ReadUint16LengthPrefixed(&fragment) {
-		return "", false
+		return nil, false
 	}
 
+	hello := parseClientHelloMessage(fragment)
+	return hello, hello != nil
+}
+
+// parseClientHelloMessage parses a handshake message (including its header)
+// that should contain a ClientHello. Returns nil if it is malformed. Like the
+// original SNI parser, messages without extensions are rejected, but
+// extensions after the server_name extension may be malformed.
+func parseClientHelloMessage(message []byte) *ClientHello {
+	fragment := cryptobyte.String(message)
+
 	// parse Handshake message
 	var msgType uint8
 	var clientHello cryptobyte.String
 	if !fragment.ReadUint8(&msgType) || msgType != typeClientHello ||
 		!fragment.ReadUint24LengthPrefixed(&clientHello) {
-		return "", false
+		return nil
+	}
+
+	hello := &ClientHello{}
+	var random []byte
+	var sessionID, cipherSuites, compressionMethods cryptobyte.String
+	if !clientHello.ReadUint16(&hello.Version) ||
+		!(hello.Version >= tls.VersionTLS10 && hello.Version <= tls.VersionTLS12) ||
+		!clientHello.ReadBytes(&random, 32) ||
+		!clientHello.ReadUint8LengthPrefixed(&sessionID) ||
+		!clientHello.ReadUint16LengthPrefixed(&cipherSuites) ||
+		!clientHello.ReadUint8LengthPrefixed(&compressionMethods) {
+		return nil
+	}
+	hello.Random = append([]byte{}, random...)
+	hello.SessionID = append([]byte{}, sessionID...)
+	if !readUint16List(&cipherSuites, &hello.CipherSuites) {
+		return nil
 	}
+	hello.CompressionMethods = readUint8List(compressionMethods)
 
-	// Parse Client Hello message (ignore random, SID, cipher suites,
-	// compression methods, only preserve extensions).
-	var tlsVersion uint16
-	var ignore, exts cryptobyte.String
-	if !clientHello.ReadUint16(&tlsVersion) ||
-		!(tlsVersion >= tls.VersionTLS10 && tlsVersion <= tls.VersionTLS12) ||
-		!clientHello.Skip(32) ||
-		!clientHello.ReadUint8LengthPrefixed(&ignore) ||
-		!clientHello.ReadUint16LengthPrefixed(&ignore) ||
-		!clientHello.ReadUint8LengthPrefixed(&ignore) ||
-		!clientHello.ReadUint16LengthPrefixed(&exts) {
-		return "", false
+	var exts cryptobyte.String
+	if !clientHello.ReadUint16LengthPrefixed(&exts) {
+		return nil
 	}
 
 	// Parse extensions
+	hello.Extensions = []uint16{}
+	hasServerName := false
 	for !exts.Empty() {
 		var extType uint16
 		var extData cryptobyte.String
 		if !exts.ReadUint16(&extType) ||
 			!exts.ReadUint16LengthPrefixed(&extData) {
-			return "", false
+			if hasServerName {
+				// keep the SNI, the remainder is ignored.
+				break
+			}
+			return nil
 		}
-		if extType != extensionServerName {
+		hello.Extensions = append(hello.Extensions, extType)
+		if extType == extensionServerName {
+			if !parseServerNameExtension(extData, hello) {
+				return nil
+			}
+			hasServerName = true
 			continue
 		}
+		// other extensions are decoded on a best-effort basis, a
+		// malformed extension does not invalidate the message.
+		parseExtension(extType, extData, hello)
+	}
+	return hello
+}
 
-		var serverNameList cryptobyte.String
-		if !extData.ReadUint16LengthPrefixed(&serverNameList) {
-			return "", false
+// parseServerNameExtension extracts the host name from the server_name
+// extension.
+func parseServerNameExtension(extData cryptobyte.String, hello *ClientHello) bool {
+	var serverNameList cryptobyte.String
+	if !extData.ReadUint16LengthPrefixed(&serverNameList) {
+		return false
+	}
+	for !serverNameList.Empty() {
+		var nameType uint8
+		var hostName cryptobyte.String
+		if !serverNameList.ReadUint8(&nameType) ||
+			!serverNameList.ReadUint16LengthPrefixed(&hostName) {
+			return false
+		}
+		if nameType == sniTypeHostname {
+			hello.ServerName = string(hostName)
+			return true
 		}
-		for !serverNameList.Empty() {
-			var nameType uint8
-			if !serverNameList.ReadUint8(&nameType) {
-				return "", false
+	}
+	// no host name
+	return true
+}
+
+// parseExtension decodes the contents of a known extension.
+func parseExtension(extType uint16, extData cryptobyte.String, hello *ClientHello) {
+	var list cryptobyte.String
+	switch extType {
+	case extensionSupportedGroups:
+		if extData.ReadUint16LengthPrefixed(&list) {
+			readUint16List(&list, &hello.SupportedGroups)
+		}
+	case extensionECPointFormats:
+		if extData.ReadUint8LengthPrefixed(&list) {
+			hello.ECPointFormats = readUint8List(list)
+		}
+	case extensionSignatureAlgorithms:
+		if extData.ReadUint16LengthPrefixed(&list) {
+			readUint16List(&list, &hello.SignatureAlgorithms)
+		}
+	case extensionALPN:
+		if extData.ReadUint16LengthPrefixed(&list) {
+			for !list.Empty() {
+				var protocol cryptobyte.String
+				if !list.ReadUint8LengthPrefixed(&protocol) {
+					break
+				}
+				hello.ALPNProtocols = append(hello.ALPNProtocols, string(protocol))
 			}
-			if nameType != sniTypeHostname {
-				continue
+		}
+	case extensionPadding:
+		hello.PaddingLength = len(extData)
+	case extensionSupportedVersions:
+		if extData.ReadUint8LengthPrefixed(&list) {
+			readUint16List(&list, &hello.SupportedVersions)
+		}
+	case extensionPSKKeyExchangeModes:
+		if extData.ReadUint8LengthPrefixed(&list) {
+			hello.PSKModes = readUint8List(list)
+		}
+	case extensionKeyShare, extensionKeyShareDraft22:
+		if extData.ReadUint16LengthPrefixed(&list) {
+			for !list.Empty() {
+				var group uint16
+				var keyExchange cryptobyte.String
+				if !list.ReadUint16(&group) ||
+					!list.ReadUint16LengthPrefixed(&keyExchange) {
+					break
+				}
+				hello.KeyShareGroups = append(hello.KeyShareGroups, group)
 			}
+		}
+	}
+}
 
-			var hostName cryptobyte.String
-			if !serverNameList.ReadUint16LengthPrefixed(&hostName) {
-				return "", false
-			}
-			return string(hostName), true
+// readUint16List reads all values from the input. Returns false if the input
+// is not a multiple of two bytes (values that could be read are kept).
+func readUint16List(input *cryptobyte.String, values *[]uint16) bool {
+	*values = []uint16{}
+	for !input.Empty() {
+		var value uint16
+		if !input.ReadUint16(&value) {
+			return false
 		}
+		*values = append(*values, value)
+	}
+	return true
+}
+
+// readUint8List returns all bytes from the input.
+func readUint8List(input cryptobyte.String) []int {
+	values := []int{}
+	for _, value := range input {
+		values = append(values, int(value))
+	}
+	return values
+}
+
+// ServerHello contains the fields of a ServerHello message.
+type ServerHello struct {
+	// legacy_version
+	Version           uint16
+	Random            []byte
+	SessionID         []byte
+	CipherSuite       uint16
+	CompressionMethod uint8
+	// Extension types in the order in which they appear.
+	Extensions []uint16
+}
+
+// parseServerHelloMessage parses a handshake message (including its header)
+// that should contain a ServerHello. Returns nil if it is malformed.
+func parseServerHelloMessage(message []byte) *ServerHello {
+	input := cryptobyte.String(message)
+
+	var msgType uint8
+	var serverHello cryptobyte.String
+	if !input.ReadUint8(&msgType) || msgType != typeServerHello ||
+		!input.ReadUint24LengthPrefixed(&serverHello) {
+		return nil
+	}
 
-		// extensions must be unique
-		return "", false
+	hello := &ServerHello{}
+	var sessionID cryptobyte.String
+	if !serverHello.ReadUint16(&hello.Version) ||
+		!serverHello.ReadBytes(&hello.Random, 32) ||
+		!serverHello.ReadUint8LengthPrefixed(&sessionID) ||
+		!serverHello.ReadUint16(&hello.CipherSuite) ||
+		!serverHello.ReadUint8(&hello.CompressionMethod) {
+		return nil
 	}
+	hello.Random = append([]byte{}, hello.Random...)
+	hello.SessionID = append([]byte{}, sessionID...)
 
-	// server_name extension not found
-	return "", true
+	// extensions are optional.
+	if serverHello.Empty() {
+		return hello
+	}
+	var exts cryptobyte.String
+	if !serverHello.ReadUint16LengthPrefixed(&exts) {
+		return nil
+	}
+	hello.Extensions = []uint16{}
+	for !exts.Empty() {
+		var extType uint16
+		var extData cryptobyte.String
+		if !exts.ReadUint16(&extType) ||
+			!exts.ReadUint16LengthPrefixed(&extData) {
+			return nil
+		}
+		hello.Extensions = append(hello.Extensions, extType)
+	}
+	return hello
 }
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"reflect"
 	"testing"
+
+	"golang.org/x/crypto/cryptobyte"
 )
 
 // extracted from capture from SSL labs against mitm.watch (empty SID, two
@@ -15,9 +19,222 @@ var clientHelloRecord = []byte{
 }
 
 func TestClientHelloParser(t *testing.T) {
-	sni := parseClientHello(clientHelloRecord)
+	hello, isTLS := parseClientHello(clientHelloRecord)
+	if !isTLS || hello == nil {
+		t.Fatal("failed to parse ClientHello")
+	}
 	expectedSni := "mitm.watch"
-	if expectedSni != sni {
-		t.Errorf("expected %v, got %v", expectedSni, sni)
+	if expectedSni != hello.ServerName {
+		t.Errorf("expected %v, got %v", expectedSni, hello.ServerName)
+	}
+	if hello.Version != 0x0302 || len(hello.SessionID) != 0 ||
+		!reflect.DeepEqual(hello.CipherSuites, []uint16{0x002f, 0x00ff}) ||
+		!reflect.DeepEqual(hello.Extensions, []uint16{extensionServerName}) {
+		t.Errorf("unexpected ClientHello: %+v", hello)
+	}
+}
+
+// buildClientHello returns a ClientHello handshake message with the given
+// extensions (nil omits the extensions).
+func buildClientHello(addExtensions func(b *cryptobyte.Builder)) []byte {
+	var b cryptobyte.Builder
+	b.AddUint8(typeClientHello)
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16(0x0303)
+		b.AddBytes(bytes.Repeat([]byte{7}, 32))
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes([]byte{1, 2, 3, 4})
+		})
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddUint16(0x1301)
+			b.AddUint16(0xc02f)
+		})
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddUint8(0)
+		})
+		if addExtensions != nil {
+			b.AddUint16LengthPrefixed(addExtensions)
+		}
+	})
+	return b.BytesOrPanic()
+}
+
+// handshakeMessageRecord wraps a handshake message in a TLS record.
+func handshakeMessageRecord(message []byte) []byte {
+	var b cryptobyte.Builder
+	b.AddUint8(recordTypeHandshake)
+	b.AddUint16(0x0301)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(message)
+	})
+	return b.BytesOrPanic()
+}
+
+func addServerNameExtension(b *cryptobyte.Builder, serverName string) {
+	addExtension(b, extensionServerName, func(b *cryptobyte.Builder) {
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddUint8(sniTypeHostname)
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddBytes([]byte(serverName))
+			})
+		})
+	})
+}
+
+// addMalformedExtension adds an extension header whose length exceeds the
+// remaining data.
+func addMalformedExtension(b *cryptobyte.Builder) {
+	b.AddUint16(extensionSupportedGroups)
+	b.AddUint16(100)
+	b.AddUint8(0)
+}
+
+func addExtension(b *cryptobyte.Builder, extType uint16, data func(b *cryptobyte.Builder)) {
+	b.AddUint16(extType)
+	b.AddUint16LengthPrefixed(data)
+}
+
+func TestParseClientHelloMessage(t *testing.T) {
+	message := buildClientHello(func(b *cryptobyte.Builder) {
+		addServerNameExtension(b, "example.com")
+		addExtension(b, extensionSupportedVersions, func(b *cryptobyte.Builder) {
+			b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddUint16(0x7f16)
+				b.AddUint16(0x0303)
+			})
+		})
+		addExtension(b, extensionSupportedGroups, func(b *cryptobyte.Builder) {
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddUint16(29)
+				b.AddUint16(23)
+			})
+		})
+		addExtension(b, extensionKeyShareDraft22, func(b *cryptobyte.Builder) {
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddUint16(29)
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddBytes(make([]byte, 32))
+				})
+			})
+		})
+		addExtension(b, extensionSignatureAlgorithms, func(b *cryptobyte.Builder) {
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddUint16(0x0804)
+			})
+		})
+		addExtension(b, extensionALPN, func(b *cryptobyte.Builder) {
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				for _, protocol := range []string{"h2", "http/1.1"} {
+					b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+						b.AddBytes([]byte(protocol))
+					})
+				}
+			})
+		})
+		addExtension(b, extensionPSKKeyExchangeModes, func(b *cryptobyte.Builder) {
+			b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddUint8(1)
+			})
+		})
+		addExtension(b, extensionECPointFormats, func(b *cryptobyte.Builder) {
+			b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddUint8(0)
+			})
+		})
+		// malformed extensions are tolerated.
+		addExtension(b, extensionSupportedGroups+1000, func(b *cryptobyte.Builder) {
+			b.AddUint8(1)
+		})
+		addExtension(b, extensionPadding, func(b *cryptobyte.Builder) {
+			b.AddBytes(make([]byte, 5))
+		})
+	})
+
+	hello := parseClientHelloMessage(message)
+	if hello == nil {
+		t.Fatal("failed to parse ClientHello")
+	}
+	expected := &ClientHello{
+		Version:            0x0303,
+		Random:             bytes.Repeat([]byte{7}, 32),
+		SessionID:          []byte{1, 2, 3, 4},
+		CipherSuites:       []uint16{0x1301, 0xc02f},
+		CompressionMethods: []int{0},
+		Extensions: []uint16{
+			extensionServerName,
+			extensionSupportedVersions,
+			extensionSupportedGroups,
+			extensionKeyShareDraft22,
+			extensionSignatureAlgorithms,
+			extensionALPN,
+			extensionPSKKeyExchangeModes,
+			extensionECPointFormats,
+			extensionSupportedGroups + 1000,
+			extensionPadding,
+		},
+		ServerName:          "example.com",
+		SupportedVersions:   []uint16{0x7f16, 0x0303},
+		SupportedGroups:     []uint16{29, 23},
+		ECPointFormats:      []int{0},
+		KeyShareGroups:      []uint16{29},
+		SignatureAlgorithms: []uint16{0x0804},
+		ALPNProtocols:       []string{"h2", "http/1.1"},
+		PSKModes:            []int{1},
+		PaddingLength:       5,
+	}
+	if !reflect.DeepEqual(hello, expected) {
+		t.Errorf("unexpected ClientHello:\n got: %+v\nwant: %+v", hello, expected)
+	}
+}
+
+func TestParseClientHelloMalformed(t *testing.T) {
+	for _, record := range [][]byte{
+		nil,
+		{0x16, 0x03, 0x01},
+		clientHelloRecord[:len(clientHelloRecord)-1],
+		// not a handshake record
+		append([]byte{0x17}, clientHelloRecord[1:]...),
+		// no extensions
+		handshakeMessageRecord(buildClientHello(nil)),
+		// malformed extension before the server_name extension
+		handshakeMessageRecord(buildClientHello(func(b *cryptobyte.Builder) {
+			addMalformedExtension(b)
+			addServerNameExtension(b, "example.com")
+		})),
+	} {
+		if hello, isTLS := parseClientHello(record); hello != nil || isTLS {
+			t.Errorf("expected failure for %x", record)
+		}
+	}
+
+	// extensions after the SNI are not needed for routing the connection.
+	record := handshakeMessageRecord(buildClientHello(func(b *cryptobyte.Builder) {
+		addServerNameExtension(b, "example.com")
+		addMalformedExtension(b)
+	}))
+	hello, isTLS := parseClientHello(record)
+	if !isTLS || hello == nil || hello.ServerName != "example.com" ||
+		!reflect.DeepEqual(hello.Extensions, []uint16{extensionServerName}) {
+		t.Errorf("expected SNI despite malformed extension, got %+v", hello)
+	}
+}
+
+func TestParseServerHelloMessage(t *testing.T) {
+	message := handshakeRecord(typeServerHello, serverHelloBody(1))[5:]
+	hello := parseServerHelloMessage(message)
+	if hello == nil {
+		t.Fatal("failed to parse ServerHello")
+	}
+	expected := &ServerHello{
+		Version:     0x0303,
+		Random:      bytes.Repeat([]byte{1}, 32),
+		SessionID:   []byte{},
+		CipherSuite: 0x1301,
+	}
+	if !reflect.DeepEqual(hello, expected) {
+		t.Errorf("unexpected ServerHello:\n got: %+v\nwant: %+v", hello, expected)
+	}
+	if parseServerHelloMessage(message[:len(message)-1]) != nil {
+		t.Error("expected failure for truncated ServerHello")
 	}
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net"
+	"time"
 )
 
 // GenerateUUIDv4 creates a v4 UUID which is derives from random numbers.
@@ -22,6 +23,16 @@ func GenerateUUIDv4() string {
 	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
 }
 
+// GenerateToken creates a random secret token (hex-encoded).
+func GenerateToken() string {
+	token := make([]byte, 32)
+	_, err := rand.Read(token)
+	if err != nil {
+		panic("rand.Read should always return data")
+	}
+	return hex.EncodeToString(token)
+}
+
 // ValidateUUID checks if the given string looks like a UUID.
 func ValidateUUID(str string) bool {
 	if len(str) != 36 {
@@ -53,3 +64,17 @@ func parseHost(host string) string {
 	}
 	return host
 }
+
+// runPeriodically invokes fn now and then after every interval (at least one
+// second), it never returns.
+func runPeriodically(interval time.Duration, fn func()) {
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		fn()
+		<-ticker.C
+	}
+}
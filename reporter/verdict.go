@@ -0,0 +1,201 @@
+// Server-side MITM detection by comparing client and server captures.
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"database/sql"
+)
+
+// Reasons for a MITM verdict. An empty reason means that no interception was
+// detected.
+const (
+	// the key of the certificate received by the client differs from ours
+	// (checked by the client against the SPKI pin), for example because a
+	// MITM re-signs with its own CA.
+	mitmReasonPinMismatch = "pin_mismatch"
+	// the certificate received by the client differs from ours (checked by
+	// the reporter). If the client reported a matching pin, the key is
+	// the same, but the certificate was altered otherwise.
+	mitmReasonCertificateSubstituted = "certificate_substituted"
+
+	mitmReasonMissingServerCapture = "missing_server_capture"
+	mitmReasonVersionMismatch      = "version_mismatch"
+	// both sides negotiated the same version, but not the one that the
+	// subtest expects (the ClientHello was likely altered).
+	mitmReasonUnexpectedVersion    = "unexpected_version"
+	mitmReasonParameterMismatch    = "parameter_mismatch"
+	mitmReasonServerRandomMismatch = "server_random_mismatch"
+	mitmReasonClientHelloMismatch  = "client_hello_mismatch"
+	mitmReasonServerHelloMismatch  = "server_hello_mismatch"
+	// more than one connection reached the server (for example, a
+	// middlebox that probes the server first).
+	mitmReasonExtraConnection        = "extra_connection"
+	mitmReasonExtraConnectionOtherIP = "extra_connection_other_ip"
+)
+
+// Outcomes of the TLS 1.3 downgrade protection for subtests that allow TLS 1.3,
+// but where the client received a ServerHello for an older version. An empty
+// verdict means that no downgrade happened (or nothing could be determined).
+const (
+	// the client received the sentinel from our server, the ClientHello
+	// was downgraded on its way to the server.
+	downgradeVerdictSentinelReceived = "sentinel_received"
+	// our server sent the sentinel, but the client did not receive it.
+	downgradeVerdictSentinelStripped = "sentinel_stripped"
+	// our server did not send a sentinel, the connection was downgraded
+	// by a middlebox that terminates TLS.
+	downgradeVerdictNoSentinel = "no_sentinel"
+	// our server disabled TLS 1.3 on purpose since the ClientHello offered
+	// other TLS 1.3 versions than the expected one.
+	downgradeVerdictVersionNotOffered = "version_not_offered"
+)
+
+// computeMitmVerdict compares the client capture of a subtest with the server
+// captures and the expected version (zero if unknown) and returns the reason
+// why the connection is believed to be intercepted.
+func computeMitmVerdict(expectedVersion uint16, clientCapture *ClientCapture, serverCaptures []*ServerCapture) string {
+	if clientCapture == nil {
+		// nothing to compare against.
+		return ""
+	}
+	// a substituted certificate without pin mismatch is distinguished
+	// from one with another key.
+	if clientCapture.PinStatus == pinStatusMismatch {
+		return mitmReasonPinMismatch
+	}
+	if clientCapture.CertSubstituted {
+		return mitmReasonCertificateSubstituted
+	}
+	clientSucceeded := clientCapture.ActualTLSVersion != 0
+	if len(serverCaptures) == 0 {
+		// a successful handshake must have reached our server.
+		if clientSucceeded {
+			return mitmReasonMissingServerCapture
+		}
+		return ""
+	}
+
+	client := clientHandshakeView(clientCapture)
+	serverCapture, server := matchServerCapture(client, serverCaptures)
+
+	if clientSucceeded && clientCapture.ActualTLSVersion != serverCapture.ActualTLSVersion {
+		return mitmReasonVersionMismatch
+	}
+	if clientSucceeded && expectedVersion != 0 && clientCapture.ActualTLSVersion != expectedVersion {
+		return mitmReasonUnexpectedVersion
+	}
+	// older clients do not report the negotiated parameters.
+	if clientSucceeded && clientCapture.CipherSuite != 0 &&
+		!sameNegotiatedParams(&clientCapture.Capture, &serverCapture.Capture) {
+		return mitmReasonParameterMismatch
+	}
+	if client.ServerHello != nil && server.ServerHello != nil &&
+		!bytes.Equal(serverHelloRandom(client.ServerHello), serverHelloRandom(server.ServerHello)) {
+		return mitmReasonServerRandomMismatch
+	}
+	if client.ClientHello != nil && server.ClientHello != nil &&
+		!bytes.Equal(client.ClientHello, server.ClientHello) {
+		return mitmReasonClientHelloMismatch
+	}
+	if client.ServerHello != nil && server.ServerHello != nil &&
+		!bytes.Equal(client.ServerHello, server.ServerHello) {
+		return mitmReasonServerHelloMismatch
+	}
+	if len(serverCaptures) > 1 {
+		for _, capture := range serverCaptures {
+			if !capture.ClientIP.Equal(serverCapture.ClientIP) {
+				return mitmReasonExtraConnectionOtherIP
+			}
+		}
+		return mitmReasonExtraConnection
+	}
+	return ""
+}
+
+// computeDowngradeVerdict checks whether a connection that should have used TLS
+// 1.3 was downgraded and whether the downgrade protection was effective.
+func computeDowngradeVerdict(maxTLSVersion uint16, clientCapture *ClientCapture, serverCaptures []*ServerCapture) string {
+	if maxTLSVersion != tls.VersionTLS13 || clientCapture == nil {
+		return ""
+	}
+	client := clientHandshakeView(clientCapture)
+	if client.ServerHello == nil {
+		// the handshake failed before, nothing was negotiated.
+		return ""
+	}
+	// TLS 1.3 (draft 22 and later) selects the version via an extension.
+	if serverHelloExtension(client.ServerHello, extensionSupportedVersions) != nil {
+		return ""
+	}
+	var server handshakeView
+	if len(serverCaptures) > 0 {
+		var serverCapture *ServerCapture
+		serverCapture, server = matchServerCapture(client, serverCaptures)
+		// not a downgrade by a middlebox.
+		if serverCapture.TLS13Disabled {
+			return downgradeVerdictVersionNotOffered
+		}
+	}
+	if clientCapture.DowngradeSentinel || hasDowngradeSentinel(client.ServerHello) {
+		return downgradeVerdictSentinelReceived
+	}
+	if server.ServerHello != nil && hasDowngradeSentinel(server.ServerHello) {
+		return downgradeVerdictSentinelStripped
+	}
+	return downgradeVerdictNoSentinel
+}
+
+// sameNegotiatedParams returns true if both sides agree on the negotiated
+// cipher suite, group, ALPN protocol, resumption and HelloRetryRequest.
+func sameNegotiatedParams(client, server *Capture) bool {
+	return client.CipherSuite == server.CipherSuite &&
+		client.KeyExchangeGroup == server.KeyExchangeGroup &&
+		client.ALPNProtocol == server.ALPNProtocol &&
+		client.IsResumed == server.IsResumed &&
+		client.HelloRetryRequest == server.HelloRetryRequest
+}
+
+// isCertificateSubstituted returns true if the leaf certificate received by
+// the client differs from the served certificate. Without certificates (for
+// example, when the handshake failed) nothing can be said.
+func isCertificateSubstituted(peerCertificates [][]byte, served *tls.Certificate) bool {
+	if len(peerCertificates) == 0 || len(served.Certificate) == 0 {
+		return false
+	}
+	return !bytes.Equal(peerCertificates[0], served.Certificate[0])
+}
+
+// countExtraConnections returns the number of server captures besides the one
+// that corresponds to the client connection.
+func countExtraConnections(serverCaptures []*ServerCapture) int {
+	if len(serverCaptures) <= 1 {
+		return 0
+	}
+	return len(serverCaptures) - 1
+}
+
+// updateSubtestVerdicts stores the MITM verdict for every subtest.
+func updateSubtestVerdicts(tx *sql.Tx, testID int) error {
+	results, err := QuerySubtestResults(tx, testID)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		reason := computeMitmVerdict(result.ExpectedVersion, result.ClientCapture, result.ServerCaptures)
+		downgradeVerdict := computeDowngradeVerdict(result.MaxTLSVersion, result.ClientCapture, result.ServerCaptures)
+		_, err = tx.Exec(`
+		UPDATE subtests
+		SET
+			is_mitm = $2,
+			mitm_reason = $3,
+			extra_connections = $4,
+			downgrade_verdict = $5
+		WHERE id = $1
+		`, result.ID, reason != "", reason, countExtraConnections(result.ServerCaptures), downgradeVerdict)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+// handshakeRecord wraps a handshake message in a TLS record.
+func handshakeRecord(msgType uint8, body []byte) []byte {
+	message := append([]byte{msgType, 0, byte(len(body) >> 8), byte(len(body))}, body...)
+	return append([]byte{recordTypeHandshake, 3, 1, byte(len(message) >> 8), byte(len(message))}, message...)
+}
+
+// serverHelloBody returns a (minimal) ServerHello with the given random byte.
+func serverHelloBody(random byte) []byte {
+	body := []byte{3, 3}
+	body = append(body, bytes.Repeat([]byte{random}, 32)...)
+	return append(body, 0, 0x13, 0x01, 0)
+}
+
+var changeCipherSpecRecord = []byte{recordTypeChangeCipherSpec, 3, 3, 0, 1, 1}
+
+func TestExtractHandshakeMessages(t *testing.T) {
+	clientHello := handshakeRecord(typeClientHello, []byte{3, 3, 1, 2, 3})
+	frames := []Frame{
+		// a record split over two frames
+		{IsRead: false, Data: clientHello[:3]},
+		{IsRead: true, Data: handshakeRecord(typeServerHello, serverHelloBody(1))},
+		{IsRead: false, Data: clientHello[3:]},
+		// encrypted messages after ChangeCipherSpec are ignored.
+		{IsRead: false, Data: changeCipherSpecRecord},
+		{IsRead: false, Data: handshakeRecord(20, []byte{1, 2, 3})},
+	}
+	messages := extractHandshakeMessages(frames, false)
+	if len(messages) != 1 || !bytes.Equal(messages[0], clientHello[5:]) {
+		t.Errorf("unexpected messages: %x", messages)
+	}
+	serverHello := findHandshakeMessage(extractHandshakeMessages(frames, true), typeServerHello)
+	if random := serverHelloRandom(serverHello); !bytes.Equal(random, bytes.Repeat([]byte{1}, 32)) {
+		t.Errorf("unexpected server random: %x", random)
+	}
+}
+
+func TestComputeMitmVerdict(t *testing.T) {
+	makeClient := func(clientHello, serverHello []byte) *ClientCapture {
+		return &ClientCapture{Capture: Capture{
+			ActualTLSVersion: 0x0303,
+			Frames: []Frame{
+				{IsRead: false, Data: clientHello},
+				{IsRead: true, Data: serverHello},
+			},
+		}}
+	}
+	makeServer := func(clientHello, serverHello []byte) *ServerCapture {
+		return &ServerCapture{Capture: Capture{
+			ActualTLSVersion: 0x0303,
+			Frames: []Frame{
+				{IsRead: true, Data: clientHello},
+				{IsRead: false, Data: serverHello},
+			},
+		}}
+	}
+	clientHello := handshakeRecord(typeClientHello, []byte{3, 3, 1})
+	otherClientHello := handshakeRecord(typeClientHello, []byte{3, 3, 2})
+	serverHello := handshakeRecord(typeServerHello, serverHelloBody(1))
+	otherServerHello := handshakeRecord(typeServerHello, serverHelloBody(2))
+	serverHelloOtherCipher := handshakeRecord(typeServerHello, append(serverHelloBody(1)[:35], 0x13, 0x02, 0))
+
+	versionMismatch := makeServer(clientHello, serverHello)
+	versionMismatch.ActualTLSVersion = 0x0304
+	otherIP := makeServer(otherClientHello, otherServerHello)
+	otherIP.ClientIP = net.ParseIP("192.0.2.1")
+	substituted := makeClient(clientHello, serverHello)
+	substituted.CertSubstituted = true
+	pinMismatch := makeClient(clientHello, serverHello)
+	pinMismatch.PinStatus = pinStatusMismatch
+	// a MITM that re-signs with its own key is also detected by the reporter.
+	resigned := makeClient(clientHello, serverHello)
+	resigned.CertSubstituted = true
+	resigned.PinStatus = pinStatusMismatch
+	otherCipher := makeClient(clientHello, serverHello)
+	otherCipher.CipherSuite = 0x1302
+	sameCipher := makeServer(clientHello, serverHello)
+	sameCipher.CipherSuite = 0x1302
+
+	for _, test := range []struct {
+		name           string
+		client         *ClientCapture
+		servers        []*ServerCapture
+		expectedReason string
+	}{
+		{"identical", makeClient(clientHello, serverHello),
+			[]*ServerCapture{makeServer(clientHello, serverHello)}, ""},
+		{"no client result", nil, nil, ""},
+		{"missing server capture", makeClient(clientHello, serverHello),
+			nil, mitmReasonMissingServerCapture},
+		{"version", makeClient(clientHello, serverHello),
+			[]*ServerCapture{versionMismatch}, mitmReasonVersionMismatch},
+		{"server random", makeClient(clientHello, otherServerHello),
+			[]*ServerCapture{makeServer(clientHello, serverHello)}, mitmReasonServerRandomMismatch},
+		{"client hello", makeClient(otherClientHello, serverHello),
+			[]*ServerCapture{makeServer(clientHello, serverHello)}, mitmReasonClientHelloMismatch},
+		{"server hello", makeClient(clientHello, serverHelloOtherCipher),
+			[]*ServerCapture{makeServer(clientHello, serverHello)}, mitmReasonServerHelloMismatch},
+		{"matching capture", makeClient(clientHello, serverHello),
+			[]*ServerCapture{
+				makeServer(otherClientHello, otherServerHello),
+				makeServer(clientHello, serverHello),
+			}, mitmReasonExtraConnection},
+		{"extra connection from other IP", makeClient(clientHello, serverHello),
+			[]*ServerCapture{
+				otherIP,
+				makeServer(clientHello, serverHello),
+			}, mitmReasonExtraConnectionOtherIP},
+		{"substituted certificate", substituted,
+			[]*ServerCapture{makeServer(clientHello, serverHello)}, mitmReasonCertificateSubstituted},
+		{"pin mismatch", pinMismatch,
+			[]*ServerCapture{makeServer(clientHello, serverHello)}, mitmReasonPinMismatch},
+		{"re-signed certificate", resigned,
+			[]*ServerCapture{makeServer(clientHello, serverHello)}, mitmReasonPinMismatch},
+		{"negotiated parameters", otherCipher,
+			[]*ServerCapture{makeServer(clientHello, serverHello)}, mitmReasonParameterMismatch},
+		{"same negotiated parameters", otherCipher,
+			[]*ServerCapture{sameCipher}, ""},
+	} {
+		reason := computeMitmVerdict(0x0303, test.client, test.servers)
+		if reason != test.expectedReason {
+			t.Errorf("%s: expected reason %q, got %q", test.name, test.expectedReason, reason)
+		}
+	}
+
+	// both sides agree on TLS 1.2, but the subtest expects TLS 1.3.
+	reason := computeMitmVerdict(versionTLS13Draft22, makeClient(clientHello, serverHello),
+		[]*ServerCapture{makeServer(clientHello, serverHello)})
+	if reason != mitmReasonUnexpectedVersion {
+		t.Errorf("expected reason %q, got %q", mitmReasonUnexpectedVersion, reason)
+	}
+}
+
+func TestCountExtraConnections(t *testing.T) {
+	for count, expected := range []int{0, 0, 1, 2} {
+		captures := make([]*ServerCapture, count)
+		if extra := countExtraConnections(captures); extra != expected {
+			t.Errorf("%d captures: expected %d extra connections, got %d", count, expected, extra)
+		}
+	}
+}
+
+func TestIsCertificateSubstituted(t *testing.T) {
+	served := &tls.Certificate{Certificate: [][]byte{{1, 2, 3}, {4, 5}}}
+	for _, test := range []struct {
+		name     string
+		received [][]byte
+		expected bool
+	}{
+		{"no certificates", nil, false},
+		{"same chain", [][]byte{{1, 2, 3}, {4, 5}}, false},
+		{"same leaf", [][]byte{{1, 2, 3}}, false},
+		{"other leaf", [][]byte{{1, 2, 4}, {4, 5}}, true},
+	} {
+		if substituted := isCertificateSubstituted(test.received, served); substituted != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, substituted)
+		}
+	}
+}
+
+func TestParseHandshakeParams(t *testing.T) {
+	// ServerHello with a key_share extension (x25519).
+	serverHello := append(serverHelloBody(1), 0, 8, 0, 51, 0, 4, 0, 29, 0xaa, 0xbb)
+	helloRetryRequest := append(append([]byte{3, 3}, helloRetryRequestRandom...), 0, 0x13, 0x01, 0, 0, 6, 0, 51, 0, 2, 0, 23)
+	serverKeyExchange := []byte{curveTypeNamedCurve, 0, 24, 1, 0xcc}
+	downgradedServerHello := append(append(serverHelloBody(1)[:26], downgradeSentinelTLS11...), 0, 0x00, 0x2f, 0)
+
+	for _, test := range []struct {
+		name     string
+		messages [][]byte
+		expected handshakeParams
+	}{
+		{"no messages", nil, handshakeParams{}},
+		{"TLS 1.3", [][]byte{handshakeRecord(typeServerHello, serverHello)},
+			handshakeParams{KeyExchangeGroup: 29}},
+		{"HelloRetryRequest", [][]byte{handshakeRecord(typeServerHello, helloRetryRequest)},
+			handshakeParams{KeyExchangeGroup: 23, HelloRetryRequest: true}},
+		{"old HelloRetryRequest", [][]byte{handshakeRecord(typeHelloRetryRequest, []byte{0x7f, 0x12})},
+			handshakeParams{HelloRetryRequest: true}},
+		{"TLS 1.2", [][]byte{
+			handshakeRecord(typeServerHello, serverHelloBody(1)),
+			handshakeRecord(typeServerKeyExchange, serverKeyExchange),
+		}, handshakeParams{KeyExchangeGroup: 24}},
+		{"downgrade", [][]byte{handshakeRecord(typeServerHello, downgradedServerHello)},
+			handshakeParams{DowngradeSentinel: true}},
+		{"truncated extensions", [][]byte{handshakeRecord(typeServerHello, serverHello[:len(serverHello)-3])},
+			handshakeParams{}},
+	} {
+		var frames []Frame
+		for _, message := range test.messages {
+			frames = append(frames, Frame{IsRead: true, Data: message})
+		}
+		if params := parseHandshakeParams(frames, true); params != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected, params)
+		}
+		if params := parseHandshakeParams(frames, false); params != (handshakeParams{}) {
+			t.Errorf("%s: expected no parameters for written frames, got %+v", test.name, params)
+		}
+	}
+}
+
+func TestComputeDowngradeVerdict(t *testing.T) {
+	clientHello := handshakeRecord(typeClientHello, []byte{3, 3, 1})
+	tls12ServerHello := func(sentinel []byte) []byte {
+		body := append([]byte{3, 3}, bytes.Repeat([]byte{1}, 24)...)
+		if sentinel == nil {
+			sentinel = bytes.Repeat([]byte{1}, 8)
+		}
+		body = append(append(body, sentinel...), 0, 0xc0, 0x2f, 0)
+		return handshakeRecord(typeServerHello, body)
+	}
+	tls13ServerHello := handshakeRecord(typeServerHello, append(serverHelloBody(1), 0, 6, 0, 43, 0, 2, 0x7f, 0x16))
+	withSentinel := tls12ServerHello(downgradeSentinelTLS12)
+	withoutSentinel := tls12ServerHello(nil)
+
+	makeClient := func(serverHello []byte) *ClientCapture {
+		return &ClientCapture{Capture: Capture{
+			Frames: []Frame{
+				{IsRead: false, Data: clientHello},
+				{IsRead: true, Data: serverHello},
+			},
+		}}
+	}
+	makeServer := func(serverHello []byte) []*ServerCapture {
+		return []*ServerCapture{{Capture: Capture{
+			Frames: []Frame{
+				{IsRead: true, Data: clientHello},
+				{IsRead: false, Data: serverHello},
+			},
+		}}}
+	}
+	reportedSentinel := makeClient(withoutSentinel)
+	reportedSentinel.DowngradeSentinel = true
+	tls13Disabled := makeServer(withoutSentinel)
+	tls13Disabled[0].TLS13Disabled = true
+	tls13DisabledWithSentinel := makeServer(withSentinel)
+	tls13DisabledWithSentinel[0].TLS13Disabled = true
+
+	for _, test := range []struct {
+		name          string
+		maxTLSVersion uint16
+		client        *ClientCapture
+		servers       []*ServerCapture
+		expected      string
+	}{
+		{"no client result", tls.VersionTLS13, nil, makeServer(withSentinel), ""},
+		{"TLS 1.2 subtest", tls.VersionTLS12, makeClient(withSentinel), makeServer(withSentinel), ""},
+		{"TLS 1.3", tls.VersionTLS13, makeClient(tls13ServerHello), makeServer(tls13ServerHello), ""},
+		{"no ServerHello", tls.VersionTLS13, &ClientCapture{}, makeServer(withSentinel), ""},
+		{"sentinel received", tls.VersionTLS13, makeClient(withSentinel), makeServer(withSentinel),
+			downgradeVerdictSentinelReceived},
+		{"sentinel reported by client", tls.VersionTLS13, reportedSentinel, nil,
+			downgradeVerdictSentinelReceived},
+		{"sentinel stripped", tls.VersionTLS13, makeClient(withoutSentinel), makeServer(withSentinel),
+			downgradeVerdictSentinelStripped},
+		{"no sentinel", tls.VersionTLS13, makeClient(withoutSentinel), makeServer(tls13ServerHello),
+			downgradeVerdictNoSentinel},
+		{"no server capture", tls.VersionTLS13, makeClient(withoutSentinel), nil,
+			downgradeVerdictNoSentinel},
+		{"TLS 1.3 disabled by server", tls.VersionTLS13, makeClient(withoutSentinel), tls13Disabled,
+			downgradeVerdictVersionNotOffered},
+		{"TLS 1.3 disabled by server with sentinel", tls.VersionTLS13, makeClient(withSentinel),
+			tls13DisabledWithSentinel, downgradeVerdictVersionNotOffered},
+		{"TLS 1.3 disabled by server, sentinel reported by client", tls.VersionTLS13, reportedSentinel,
+			tls13Disabled, downgradeVerdictVersionNotOffered},
+	} {
+		verdict := computeDowngradeVerdict(test.maxTLSVersion, test.client, test.servers)
+		if verdict != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, verdict)
+		}
+	}
+}
@@ -24,6 +24,11 @@ var httpClient = &http.Client{}
 // success. (Either bodies can be nil in case no body is expected.) Otherwise
 // the error reason is returned.
 func doRequest(method, path string, reqBody interface{}, respBody interface{}) error {
+	return doRequestWithHeader(method, path, nil, reqBody, respBody)
+}
+
+// doRequestWithHeader is like doRequest, but adds the given request headers.
+func doRequestWithHeader(method, path string, header http.Header, reqBody interface{}, respBody interface{}) error {
 	var requestBody io.Reader
 	if reqBody != nil {
 		json, err := json.Marshal(reqBody)
@@ -36,6 +41,9 @@ func doRequest(method, path string, reqBody interface{}, respBody interface{}) e
 	if err != nil {
 		return err
 	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
 	req.Header.Set("X-Requested-With", "net/http")
 	req.Header.Set("Content-Type", "application/json")
 
@@ -78,7 +86,7 @@ func doRequest(method, path string, reqBody interface{}, respBody interface{}) e
 }
 
 // CreateTest starts a test and obtains the test cases.
-func CreateTest(testRequest createTestRequest, anonymous bool) (string, []SubtestSpec, error) {
+func CreateTest(testRequest createTestRequest, anonymous bool) (*createTestResponse, error) {
 	var testResponse createTestResponse
 	url := "/tests"
 	if anonymous {
@@ -86,12 +94,20 @@ func CreateTest(testRequest createTestRequest, anonymous bool) (string, []Subtes
 	}
 	err := doRequest("POST", url, testRequest, &testResponse)
 	if err != nil {
-		return "", nil, err
+		return nil, err
 	}
 	if testResponse.TestID == "" {
-		return "", nil, errors.New("Missing Test ID")
+		return nil, errors.New("Missing Test ID")
 	}
-	return testResponse.TestID, testResponse.Subtests, nil
+	return &testResponse, nil
+}
+
+// DeleteTest removes the results of a test using the deletion token that was
+// obtained when the test was created.
+func DeleteTest(testId, deletionToken string) error {
+	header := http.Header{}
+	header.Set("X-Deletion-Token", deletionToken)
+	return doRequestWithHeader("DELETE", "/tests/"+testId, header, nil, nil)
 }
 
 // SaveTestResult saves the results of one test case, it must be executed only
@@ -101,3 +117,11 @@ func SaveTestResult(testId string, subtestNumber int, testResult clientResult) e
 		testId, subtestNumber)
 	return doRequest("PUT", endpoint, testResult, nil)
 }
+
+// GetTestResult retrieves the server-side view and verdict of an anonymous test
+// (this is only available to the client that created the test).
+func GetTestResult(testId string) (map[string]interface{}, error) {
+	var testResult map[string]interface{}
+	err := doRequest("GET", "/tests/"+testId+"/result", nil, &testResult)
+	return testResult, err
+}